@@ -2,13 +2,24 @@ package cmd
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coreruleset/albedo/server"
 	"github.com/spf13/cobra"
 )
 
+// adminTokenEnv is the environment variable holding the admin token if
+// neither --admin-token nor --admin-token-file is set.
+const adminTokenEnv = "ALBEDO_ADMIN_TOKEN"
+
 func Execute() error {
 	rootCmd := NewRootCommand()
 	return rootCmd.ExecuteContext(context.Background())
@@ -19,11 +30,33 @@ func NewRootCommand() *cobra.Command {
 		Use:   "albedo",
 		Short: "HTTP reflector and black hole",
 		RunE:  runE,
+		// runtime errors, such as a port that is already in use, are not
+		// caused by invalid usage
+		SilenceUsage: true,
 	}
 	rootCmd.PersistentFlags().IntP("port", "p", 8080, "port to listen on")
 	rootCmd.PersistentFlags().StringP("bind", "b", "0.0.0.0", "address to bind to")
 	rootCmd.PersistentFlags().Bool("debug", false, "Log debugging information")
 	rootCmd.PersistentFlags().Bool("json", false, "Use JSON log format instead of text")
+	rootCmd.PersistentFlags().String("tls-cert", "", "path to a PEM encoded TLS certificate; enables HTTPS")
+	rootCmd.PersistentFlags().String("tls-key", "", "path to the PEM encoded private key of the TLS certificate")
+	rootCmd.PersistentFlags().Bool("tls-self-signed", false, "serve HTTPS with a self-signed certificate generated at startup")
+	rootCmd.PersistentFlags().StringSlice("tls-san", server.DefaultSelfSignedSANs, "subject alternative names of the self-signed certificate")
+	rootCmd.PersistentFlags().Int("tls-port", 0, "serve HTTPS on this port and plain HTTP on --port")
+	rootCmd.PersistentFlags().Int("journal-capacity", server.DefaultJournalCapacity, "number of requests retained in the request journal (0 disables the journal)")
+	rootCmd.PersistentFlags().Int("journal-body-limit", server.DefaultJournalBodyLimit, "number of body bytes retained per request in the request journal")
+	rootCmd.PersistentFlags().Duration("shutdown-timeout", 10*time.Second, "time to wait for in-flight requests to complete on shutdown (0 waits indefinitely)")
+	rootCmd.PersistentFlags().Bool("h2c", false, "accept cleartext HTTP/2 (prior knowledge and upgrade) on the plain HTTP listener")
+	rootCmd.PersistentFlags().String("endpoints-file", "", "path to a YAML or JSON list of endpoint configurations to load at startup; reloaded on change")
+	rootCmd.PersistentFlags().Bool("persist", false, "write endpoint configuration changes back to --endpoints-file")
+	rootCmd.PersistentFlags().Bool("metrics", false, "expose Prometheus metrics on /metrics")
+	rootCmd.PersistentFlags().Int("admin-port", 0, "serve the built-in endpoints on this port only; all requests to the other ports are treated as ordinary traffic")
+	rootCmd.PersistentFlags().String("admin-bind", "", "address to bind the admin listener to (defaults to --bind)")
+	rootCmd.PersistentFlags().String("control-prefix", "", "path prefix of the built-in endpoints, e.g. /_albedo")
+	rootCmd.PersistentFlags().Bool("legacy-control-paths", false, "keep serving the built-in endpoints at their original paths when --control-prefix is set")
+	rootCmd.PersistentFlags().String("admin-token", "", "bearer token required on the built-in endpoints (default $"+adminTokenEnv+")")
+	rootCmd.PersistentFlags().String("admin-token-file", "", "path to a file holding the admin token")
+	rootCmd.PersistentFlags().String("admin-client-ca", "", "path to PEM encoded CA certificates; client certificates signed by these CAs authenticate as an alternative to the admin token (requires TLS)")
 
 	return rootCmd
 }
@@ -33,6 +66,53 @@ func runE(cmd *cobra.Command, _ []string) error {
 	binding, _ := cmd.Flags().GetString("bind")
 	debug, _ := cmd.Flags().GetBool("debug")
 	jsonLogFormat, _ := cmd.Flags().GetBool("json")
+	tlsCert, _ := cmd.Flags().GetString("tls-cert")
+	tlsKey, _ := cmd.Flags().GetString("tls-key")
+	tlsSelfSigned, _ := cmd.Flags().GetBool("tls-self-signed")
+	tlsSANs, _ := cmd.Flags().GetStringSlice("tls-san")
+	tlsPort, _ := cmd.Flags().GetInt("tls-port")
+	h2cEnabled, _ := cmd.Flags().GetBool("h2c")
+	shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+	journalCapacity, _ := cmd.Flags().GetInt("journal-capacity")
+	journalBodyLimit, _ := cmd.Flags().GetInt("journal-body-limit")
+	endpointsFile, _ := cmd.Flags().GetString("endpoints-file")
+	persist, _ := cmd.Flags().GetBool("persist")
+	metricsEnabled, _ := cmd.Flags().GetBool("metrics")
+	adminPort, _ := cmd.Flags().GetInt("admin-port")
+	adminBinding, _ := cmd.Flags().GetString("admin-bind")
+	if adminBinding != "" && adminPort == 0 {
+		return errors.New("--admin-bind requires --admin-port")
+	}
+	controlPrefix, _ := cmd.Flags().GetString("control-prefix")
+	legacyControlPaths, _ := cmd.Flags().GetBool("legacy-control-paths")
+	if controlPrefix != "" {
+		if err := server.ValidateControlPrefix(controlPrefix); err != nil {
+			return fmt.Errorf("--control-prefix: %w", err)
+		}
+	}
+	if legacyControlPaths && controlPrefix == "" {
+		return errors.New("--legacy-control-paths requires --control-prefix")
+	}
+	adminToken, err := readAdminToken(cmd)
+	if err != nil {
+		return err
+	}
+	adminClientCA, _ := cmd.Flags().GetString("admin-client-ca")
+	var adminClientCAs *x509.CertPool
+	if adminClientCA != "" {
+		if adminToken == "" {
+			return errors.New("--admin-client-ca requires an admin token")
+		}
+		if tlsCert == "" && tlsKey == "" && !tlsSelfSigned {
+			return errors.New("--admin-client-ca requires TLS")
+		}
+		if adminClientCAs, err = loadCertPool(adminClientCA); err != nil {
+			return err
+		}
+	}
+	if persist && endpointsFile == "" {
+		return errors.New("--persist requires --endpoints-file")
+	}
 	logLevel := slog.LevelInfo
 	if debug {
 		logLevel = slog.LevelDebug
@@ -46,6 +126,86 @@ func runE(cmd *cobra.Command, _ []string) error {
 	logger := slog.New(handler)
 	slog.SetDefault(logger)
 
-	server.Start(binding, port)
+	config := &server.ServeConfig{
+		Binding:         binding,
+		Port:            port,
+		TLSPort:         tlsPort,
+		H2C:             h2cEnabled,
+		ShutdownTimeout: shutdownTimeout,
+		AdminPort:       adminPort,
+		AdminBinding:    adminBinding,
+	}
+	if tlsCert != "" || tlsKey != "" || tlsSelfSigned {
+		config.TLS = &server.TLSConfig{
+			CertFile:   tlsCert,
+			KeyFile:    tlsKey,
+			SelfSigned: tlsSelfSigned,
+			SANs:       tlsSANs,
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	options := []server.Option{
+		server.WithJournalCapacity(journalCapacity),
+		server.WithJournalBodyLimit(journalBodyLimit),
+	}
+	if endpointsFile != "" {
+		options = append(options, server.WithEndpointsFile(endpointsFile, persist))
+	}
+	if metricsEnabled {
+		options = append(options, server.WithMetrics())
+	}
+	if controlPrefix != "" {
+		options = append(options, server.WithControlPrefix(controlPrefix, legacyControlPaths))
+	}
+	if adminToken != "" {
+		options = append(options, server.WithAdminToken(adminToken))
+	}
+	if adminClientCAs != nil {
+		options = append(options, server.WithAdminClientCAs(adminClientCAs))
+	}
+	albedo := server.New(options...)
+	if err := albedo.Serve(ctx, config); err != nil {
+		return err
+	}
+	slog.Info("Server stopped")
 	return nil
 }
+
+// readAdminToken returns the admin token from --admin-token, --admin-token-file
+// or the environment, in this order.
+func readAdminToken(cmd *cobra.Command) (string, error) {
+	token, _ := cmd.Flags().GetString("admin-token")
+	tokenFile, _ := cmd.Flags().GetString("admin-token-file")
+	if token != "" && tokenFile != "" {
+		return "", errors.New("--admin-token and --admin-token-file are mutually exclusive")
+	}
+	if tokenFile != "" {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read admin token: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+		if token == "" {
+			return "", fmt.Errorf("admin token file %s is empty", tokenFile)
+		}
+	}
+	if token == "" {
+		token = os.Getenv(adminTokenEnv)
+	}
+	return token, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
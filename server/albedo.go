@@ -0,0 +1,262 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+// Albedo is an instance of the reflector. Every instance owns its own registry
+// of dynamic endpoints, its capabilities and its logger, so that multiple
+// instances can be used side by side (e.g., in parallel tests).
+type Albedo struct {
+	logger           *slog.Logger
+	capabilities     *CapabilitiesSpec
+	capabilitiesOnce sync.Once
+	connections      atomic.Uint64
+	templateCounter  atomic.Uint64
+	endpointsFile    *endpointsFile
+	journalCapacity  int
+	journalBodyLimit int
+	// global is the namespace of requests without namespace, holding
+	// endpoints and journal
+	global          *namespace
+	namespacesMutex sync.Mutex
+	namespaces      map[string]*namespace
+	// metrics is nil if metrics are disabled
+	metrics *metrics
+	// controlPrefix is prepended to the paths of the built-in endpoints
+	controlPrefix      string
+	controlPrefixErr   error
+	legacyControlPaths bool
+	// adminToken is empty if the built-in endpoints don't require
+	// authentication
+	adminToken     string
+	adminClientCAs *x509.CertPool
+}
+
+// Option configures an Albedo instance created with New.
+type Option func(*Albedo)
+
+// WithLogger sets the logger used by the instance. If no logger is set, the
+// instance logs to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *Albedo) {
+		a.logger = logger
+	}
+}
+
+// WithCapabilities replaces the capabilities description reported by the
+// "/capabilities" endpoint.
+func WithCapabilities(capabilities *CapabilitiesSpec) Option {
+	return func(a *Albedo) {
+		a.capabilities = capabilities
+	}
+}
+
+// WithJournalCapacity sets the number of requests retained by the request
+// journal. A capacity of 0 disables the journal.
+func WithJournalCapacity(capacity int) Option {
+	return func(a *Albedo) {
+		a.journalCapacity = capacity
+	}
+}
+
+// WithJournalBodyLimit sets the number of body bytes the request journal
+// retains per request.
+func WithJournalBodyLimit(limit int) Option {
+	return func(a *Albedo) {
+		a.journalBodyLimit = limit
+	}
+}
+
+// WithEndpointsFile preloads the dynamic endpoints from a file holding a list
+// of "/configure_reflection" specifications in YAML or JSON. Serve loads the
+// file before accepting requests and reloads it whenever it changes. If
+// persist is true, configuration changes are written back to the file.
+func WithEndpointsFile(path string, persist bool) Option {
+	return func(a *Albedo) {
+		a.endpointsFile = &endpointsFile{path: path, persist: persist}
+	}
+}
+
+// WithMetrics enables the "/metrics" endpoint, which exposes metrics about
+// the traffic and the configuration in the Prometheus text exposition format.
+func WithMetrics() Option {
+	return func(a *Albedo) {
+		a.metrics = newMetrics()
+	}
+}
+
+// WithControlPrefix moves the built-in endpoints below the given path
+// prefix, e.g., "/_albedo/reflect" for the prefix "/_albedo", so that they
+// don't shadow the URLs under test. If legacyPaths is true, the built-in
+// endpoints remain available at their original paths as well. Handler,
+// ControlHandler and Serve reject invalid prefixes, see
+// ValidateControlPrefix.
+func WithControlPrefix(prefix string, legacyPaths bool) Option {
+	return func(a *Albedo) {
+		a.controlPrefix, a.controlPrefixErr = cleanControlPrefix(prefix)
+		a.legacyControlPaths = legacyPaths
+	}
+}
+
+// ValidateControlPrefix checks that prefix can be passed to
+// WithControlPrefix. A prefix must be a path starting with a slash, without
+// wildcards and whitespace.
+func ValidateControlPrefix(prefix string) error {
+	_, err := cleanControlPrefix(prefix)
+	return err
+}
+
+// cleanControlPrefix returns the cleaned prefix without trailing slash.
+func cleanControlPrefix(prefix string) (string, error) {
+	if !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("invalid control prefix '%s': must start with '/'", prefix)
+	}
+	// ServeMux treats braces as wildcards and whitespace as the separator
+	// between method and path
+	if strings.ContainsFunc(prefix, func(r rune) bool {
+		return r == '{' || r == '}' || unicode.IsSpace(r) || unicode.IsControl(r)
+	}) {
+		return "", fmt.Errorf("invalid control prefix '%s': must not contain braces or whitespace", prefix)
+	}
+	return strings.TrimSuffix(path.Clean(prefix), "/"), nil
+}
+
+// WithAdminToken requires requests to the built-in endpoints to carry the
+// token in an "Authorization: Bearer" header. Requests to the dynamic
+// endpoints and the default endpoint don't require authentication.
+func WithAdminToken(token string) Option {
+	return func(a *Albedo) {
+		a.adminToken = token
+	}
+}
+
+// WithAdminClientCAs allows requests to the built-in endpoints to
+// authenticate with a client certificate signed by one of the given CAs, as
+// an alternative to the admin token. The HTTPS listeners started by Serve
+// request client certificates accordingly. Only takes effect together with
+// WithAdminToken.
+func WithAdminClientCAs(pool *x509.CertPool) Option {
+	return func(a *Albedo) {
+		a.adminClientCAs = pool
+	}
+}
+
+// New creates a new, isolated Albedo instance.
+func New(opts ...Option) *Albedo {
+	a := &Albedo{
+		journalCapacity:  DefaultJournalCapacity,
+		journalBodyLimit: DefaultJournalBodyLimit,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.global = &namespace{
+		endpoints: newEndpointRegistry(),
+		journal:   newJournal(a.journalCapacity, a.journalBodyLimit),
+	}
+	a.namespaces = map[string]*namespace{}
+	return a
+}
+
+// Handler returns the HTTP handler serving all of albedo's endpoints for
+// this instance. It panics if the control prefix is invalid.
+func (a *Albedo) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.handleDefault)
+	a.registerControlEndpoints(mux)
+	return a.wrapHandler(mux)
+}
+
+// ControlHandler returns the HTTP handler serving only the built-in endpoints
+// of this instance, such as "/reflect" and "/configure_reflection". Requests
+// to other paths receive 404. It panics if the control prefix is invalid.
+func (a *Albedo) ControlHandler() http.Handler {
+	mux := http.NewServeMux()
+	a.registerControlEndpoints(mux)
+	return a.wrapHandler(mux)
+}
+
+// DataHandler returns the HTTP handler serving the traffic under test. Every
+// path, including the paths of the built-in endpoints, is served by the
+// dynamic endpoints or the default endpoint. Paths below "/_session/" only
+// select a namespace if the namespace exists.
+func (a *Albedo) DataHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.handleDefault)
+	return withRequestInfo(a.withDataNamespace(a.withMetrics(mux)))
+}
+
+func (a *Albedo) registerControlEndpoints(mux *http.ServeMux) {
+	if a.controlPrefixErr != nil {
+		panic(a.controlPrefixErr)
+	}
+	a.registerControlRoutes(mux, a.controlPrefix)
+	if a.controlPrefix != "" && a.legacyControlPaths {
+		a.registerControlRoutes(mux, "")
+	}
+}
+
+// registerControlRoutes registers the built-in endpoints below prefix.
+func (a *Albedo) registerControlRoutes(mux *http.ServeMux, prefix string) {
+	handle := func(pattern string, handler http.HandlerFunc) {
+		handler = a.withAuthentication(handler)
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			mux.HandleFunc(prefix+pattern, handler)
+			return
+		}
+		mux.HandleFunc(method+" "+prefix+path, handler)
+	}
+
+	handle("/capabilities", a.handleCapabilities)
+	handle("/capabilities/", a.handleCapabilities)
+	handle("POST /reflect", a.handleReflect)
+	handle("POST /reflect/", a.handleReflect)
+	handle("POST /configure_reflection", a.handleConfigureReflection)
+	handle("POST /configure_reflection/", a.handleConfigureReflection)
+	handle("GET /endpoints", a.handleEndpoints)
+	handle("GET /endpoints/", a.handleEndpoints)
+	handle("GET /endpoints/{id}", a.handleGetEndpoint)
+	handle("GET /endpoints/{id}/", a.handleGetEndpoint)
+	handle("DELETE /endpoints/{id}", a.handleDeleteEndpoint)
+	handle("DELETE /endpoints/{id}/", a.handleDeleteEndpoint)
+	handle("PATCH /endpoints/{id}", a.handlePatchEndpoint)
+	handle("PATCH /endpoints/{id}/", a.handlePatchEndpoint)
+	handle("PUT /reset", a.handleReset)
+	handle("PUT /reset/", a.handleReset)
+	handle("GET /echo", a.handleEcho)
+	handle("GET /echo/", a.handleEcho)
+	handle("POST /echo", a.handleEcho)
+	handle("POST /echo/", a.handleEcho)
+	handle("/inspect", a.handleInspect)
+	handle("/inspect/", a.handleInspect)
+	handle("GET /journal", a.handleJournal)
+	handle("GET /journal/", a.handleJournal)
+	handle("GET /journal/wait", a.handleWaitForRequest)
+	handle("DELETE /journal", a.handleClearJournal)
+	handle("DELETE /journal/", a.handleClearJournal)
+	if a.metrics != nil {
+		handle("GET /metrics", a.handleMetrics)
+		handle("GET /metrics/", a.handleMetrics)
+	}
+}
+
+func (a *Albedo) wrapHandler(mux *http.ServeMux) http.Handler {
+	return withRequestInfo(a.withNamespace(a.withMetrics(mux)))
+}
+
+func (a *Albedo) log() *slog.Logger {
+	if a.logger != nil {
+		return a.logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type albedoTestSuite struct {
+	suite.Suite
+}
+
+func TestAlbedoTestSuite(t *testing.T) {
+	suite.Run(t, new(albedoTestSuite))
+}
+
+// serverSuite is embedded by test suites that send requests to the handler
+// of an instance.
+type serverSuite struct {
+	suite.Suite
+	albedo *Albedo
+	server *httptest.Server
+}
+
+// serve starts a test server for a new instance configured with opts.
+func (s *serverSuite) serve(opts ...Option) {
+	s.albedo = New(opts...)
+	s.server = httptest.NewServer(s.albedo.Handler())
+	s.T().Cleanup(s.server.Close)
+}
+
+// serveWire is like serve, but records the headers of requests as received
+// and attributes requests to their connections, as Serve does.
+func (s *serverSuite) serveWire(opts ...Option) {
+	s.albedo = New(opts...)
+	s.server = httptest.NewUnstartedServer(s.albedo.Handler())
+	s.server.Listener = &wireListener{s.server.Listener}
+	s.server.Config.ConnContext = s.albedo.connContext
+	s.server.Start()
+	s.T().Cleanup(s.server.Close)
+}
+
+// dial opens a connection to the test server, which is closed at the end of
+// the test.
+func (s *serverSuite) dial() net.Conn {
+	conn, err := net.Dial("tcp", s.server.Listener.Addr().String())
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// do sends a request to the test server and returns the response along with
+// its body.
+func (s *serverSuite) do(method string, path string, body string) (*http.Response, string) {
+	return doRequest(s.T(), http.DefaultClient, method, s.server.URL+path, body, nil)
+}
+
+// doJSON sends a request with value encoded as JSON as its body, e.g. a
+// specification, and returns the response along with its body.
+func (s *serverSuite) doJSON(method string, path string, value any) (*http.Response, string) {
+	body, err := json.Marshal(value)
+	s.Require().NoError(err)
+	return s.do(method, path, string(body))
+}
+
+// doRequest sends a request with the given headers and returns the response
+// along with its body. The response body is read completely and closed.
+func doRequest(t *testing.T, client *http.Client, method string, url string, body string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	return response, string(responseBody)
+}
+
+func (s *albedoTestSuite) TestInstancesAreIsolated() {
+	first := httptest.NewServer(New().Handler())
+	s.T().Cleanup(first.Close)
+	second := httptest.NewServer(New().Handler())
+	s.T().Cleanup(second.Close)
+
+	spec := &configureReflectionSpec{
+		reflectionSpec: reflectionSpec{
+			Status: 234,
+			Body:   "configured",
+		},
+		Endpoints: []dynamicEndpointSpec{
+			{
+				Method: "GET",
+				Url:    "/foo/bar",
+			},
+		},
+	}
+	body, err := json.Marshal(spec)
+	s.Require().NoError(err)
+	client := http.Client{}
+	response, err := client.Post(first.URL+"/configure_reflection", "application/json", bytes.NewReader(body))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+
+	response, err = client.Get(first.URL + "/foo/bar")
+	s.Require().NoError(err)
+	s.Equal(234, response.StatusCode)
+	reflectedBody, err := io.ReadAll(response.Body)
+	s.Require().NoError(err)
+	s.Equal("configured", string(reflectedBody))
+
+	response, err = client.Get(second.URL + "/foo/bar")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+	reflectedBody, err = io.ReadAll(response.Body)
+	s.Require().NoError(err)
+	s.Empty(reflectedBody)
+}
+
+func (s *albedoTestSuite) TestWithCapabilities() {
+	capabilities := &CapabilitiesSpec{
+		Endpoints: []endpoint{{Path: "/custom"}},
+	}
+	server := httptest.NewServer(New(WithCapabilities(capabilities)).Handler())
+	s.T().Cleanup(server.Close)
+
+	client := http.Client{}
+	response, err := client.Get(server.URL + "/capabilities")
+	s.Require().NoError(err)
+	body, err := io.ReadAll(response.Body)
+	s.Require().NoError(err)
+
+	spec := &CapabilitiesSpec{}
+	s.Require().NoError(json.Unmarshal(body, spec))
+	s.Len(spec.Endpoints, 1)
+	s.Equal("/custom", spec.Endpoints[0].Path)
+}
+
+func (s *albedoTestSuite) TestControlPrefix() {
+	server := httptest.NewServer(New(WithControlPrefix("/_albedo/", false)).Handler())
+	s.T().Cleanup(server.Close)
+
+	response, _ := doRequest(s.T(), http.DefaultClient, "POST", server.URL+"/_albedo/configure_reflection",
+		`{"status": 201, "body": "mocked", "endpoints": [{"method": "POST", "url": "/reflect"}]}`, nil)
+	s.Equal(http.StatusOK, response.StatusCode)
+
+	// the original paths are ordinary traffic
+	response, body := doRequest(s.T(), http.DefaultClient, "POST", server.URL+"/reflect", `{"status": 503}`, nil)
+	s.Equal(201, response.StatusCode)
+	s.Equal("mocked", body)
+
+	response, _ = doRequest(s.T(), http.DefaultClient, "POST", server.URL+"/_albedo/reflect/", `{"status": 503}`, nil)
+	s.Equal(503, response.StatusCode)
+	response, _ = doRequest(s.T(), http.DefaultClient, "GET", server.URL+"/_albedo/journal?path=/reflect", "", nil)
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (s *albedoTestSuite) TestControlPrefix_Invalid() {
+	for _, prefix := range []string{"_albedo", "/{albedo}", "/_albedo }", "/a\tb"} {
+		s.Error(ValidateControlPrefix(prefix), prefix)
+		a := New(WithControlPrefix(prefix, false))
+		s.Panics(func() { a.Handler() }, prefix)
+		s.Panics(func() { a.ControlHandler() }, prefix)
+		err := a.Serve(context.Background(), &ServeConfig{Binding: "127.0.0.1", Port: freePort(s.T())})
+		s.Error(err, prefix)
+	}
+	s.NoError(ValidateControlPrefix("/"))
+	s.Equal("/_albedo/v1", New(WithControlPrefix("/_albedo//v1/", false)).controlPrefix)
+	s.Equal("", New(WithControlPrefix("/", false)).controlPrefix)
+}
+
+func (s *albedoTestSuite) TestControlPrefix_LegacyPaths() {
+	server := httptest.NewServer(New(WithControlPrefix("/_albedo", true)).Handler())
+	s.T().Cleanup(server.Close)
+
+	for _, path := range []string{"/reflect", "/_albedo/reflect"} {
+		response, _ := doRequest(s.T(), http.DefaultClient, "POST", server.URL+path, `{"status": 503}`, nil)
+		s.Equal(503, response.StatusCode, path)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// adminRealm is the realm announced in authentication challenges.
+const adminRealm = "albedo"
+
+// withAuthentication requires requests to the built-in endpoints to
+// authenticate with the admin token or, as an alternative, with a verified
+// client certificate. Without an admin token, all requests are accepted.
+func (a *Albedo) withAuthentication(next http.HandlerFunc) http.HandlerFunc {
+	if a.adminToken == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.hasClientCertificate(r) {
+			next(w, r)
+			return
+		}
+		token, found := bearerToken(r)
+		if found && subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1 {
+			next(w, r)
+			return
+		}
+
+		challenge := `Bearer realm="` + adminRealm + `"`
+		err := errors.New("Authentication required")
+		if found {
+			challenge += `, error="invalid_token"`
+			err = errors.New("Invalid admin token")
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		a.log().Info("Rejected unauthenticated request", "path", r.URL.Path)
+		a.writeError(w, http.StatusUnauthorized, errorCodeUnauthorized, err)
+	}
+}
+
+// hasClientCertificate reports whether the request was received over a TLS
+// connection with a client certificate signed by one of the admin client
+// CAs. Certificates are requested but not verified during the handshake.
+func (a *Albedo) hasClientCertificate(r *http.Request) bool {
+	if a.adminClientCAs == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         a.adminClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type authTestSuite struct {
+	serverSuite
+}
+
+func TestAuthTestSuite(t *testing.T) {
+	suite.Run(t, new(authTestSuite))
+}
+
+func (s *authTestSuite) SetupTest() {
+	s.serve(WithAdminToken("secret"))
+}
+
+// doAuthenticated sends a reflection request with the given Authorization
+// header, unless it is empty.
+func (s *authTestSuite) doAuthenticated(client *http.Client, method string, url string, authorization string) (*http.Response, string) {
+	header := http.Header{}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+	return doRequest(s.T(), client, method, url, `{"status": 201}`, header)
+}
+
+func (s *authTestSuite) TestMissingToken() {
+	response, _ := s.doAuthenticated(http.DefaultClient, "PUT", s.server.URL+"/reset", "")
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+	s.Equal(`Bearer realm="albedo"`, response.Header.Get("WWW-Authenticate"))
+
+	response, _ = s.doAuthenticated(http.DefaultClient, "GET", s.server.URL+"/capabilities", "Basic dXNlcjpzZWNyZXQ=")
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+}
+
+func (s *authTestSuite) TestInvalidToken() {
+	response, body := s.doAuthenticated(http.DefaultClient, "POST", s.server.URL+"/reflect", "Bearer wrong")
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+	s.Equal(`Bearer realm="albedo", error="invalid_token"`, response.Header.Get("WWW-Authenticate"))
+	s.Equal(errorCodeUnauthorized, response.Header.Get(errorHeader))
+	s.JSONEq(`{"code": "unauthorized", "message": "Invalid admin token"}`, body)
+}
+
+func (s *authTestSuite) TestValidToken() {
+	response, _ := s.doAuthenticated(http.DefaultClient, "POST", s.server.URL+"/reflect", "Bearer secret")
+	s.Equal(201, response.StatusCode)
+	response, _ = s.doAuthenticated(http.DefaultClient, "POST", s.server.URL+"/reflect", "bearer secret")
+	s.Equal(201, response.StatusCode)
+}
+
+func (s *authTestSuite) TestDataPlaneIsOpen() {
+	response, _ := s.doAuthenticated(http.DefaultClient, "GET", s.server.URL+"/anything", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (s *authTestSuite) TestClientCertificate() {
+	caCert, caKey := s.generateCA()
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	a := New(WithAdminToken("secret"), WithAdminClientCAs(pool))
+	config, err := a.newTLSConfig(&TLSConfig{SelfSigned: true})
+	s.Require().NoError(err)
+	server := httptest.NewUnstartedServer(a.Handler())
+	server.TLS = config
+	server.StartTLS()
+	s.T().Cleanup(server.Close)
+
+	withCertificate := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{s.generateClientCertificate(caCert, caKey)},
+	}}}
+	response, _ := s.doAuthenticated(withCertificate, "POST", server.URL+"/reflect", "")
+	s.Equal(201, response.StatusCode)
+
+	// the client certificate is optional
+	withoutCertificate := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	response, _ = s.doAuthenticated(withoutCertificate, "POST", server.URL+"/reflect", "")
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+	response, _ = s.doAuthenticated(withoutCertificate, "POST", server.URL+"/reflect", "Bearer secret")
+	s.Equal(201, response.StatusCode)
+	response, _ = s.doAuthenticated(withoutCertificate, "GET", server.URL+"/anything", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (s *authTestSuite) TestForeignClientCertificate() {
+	caCert, _ := s.generateCA()
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	a := New(WithAdminToken("secret"), WithAdminClientCAs(pool))
+	config, err := a.newTLSConfig(&TLSConfig{SelfSigned: true})
+	s.Require().NoError(err)
+	server := httptest.NewUnstartedServer(a.Handler())
+	server.TLS = config
+	server.StartTLS()
+	s.T().Cleanup(server.Close)
+
+	foreignCert, foreignKey := s.generateCA()
+	withForeignCertificate := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{s.generateClientCertificate(foreignCert, foreignKey)},
+	}}}
+	// ordinary traffic is not affected by the client certificate
+	response, _ := s.doAuthenticated(withForeignCertificate, "GET", server.URL+"/anything", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	response, _ = s.doAuthenticated(withForeignCertificate, "POST", server.URL+"/reflect", "")
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+	response, _ = s.doAuthenticated(withForeignCertificate, "POST", server.URL+"/reflect", "Bearer secret")
+	s.Equal(201, response.StatusCode)
+}
+
+func (s *authTestSuite) generateCA() (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	serial, err := randomSerialNumber()
+	s.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	s.Require().NoError(err)
+	certificate, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return certificate, key
+}
+
+func (s *authTestSuite) generateClientCertificate(caCert *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	serial, err := randomSerialNumber()
+	s.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "go-ftw"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	s.Require().NoError(err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings supported by the "compress" field of reflections.
+const (
+	encodingGzip     = "gzip"
+	encodingDeflate  = "deflate"
+	encodingBrotli   = "br"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+// validateCompression validates the "compress" field of the specification.
+func validateCompression(spec *reflectionSpec) error {
+	if spec.Compress == "" {
+		return nil
+	}
+	if len(spec.Chunks) > 0 || len(spec.EncodedChunks) > 0 {
+		return fieldErrorf("compress", "compress can't be combined with chunks, use chunked instead")
+	}
+	for _, encoding := range strings.Split(spec.Compress, ",") {
+		switch encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding {
+		case encodingGzip, encodingDeflate, encodingBrotli, encodingZstd, encodingIdentity:
+		default:
+			return fieldErrorf("compress", "invalid compression '%s'", encoding)
+		}
+	}
+	return nil
+}
+
+// compressBody encodes the body with the comma separated list of content
+// codings, in the order they are listed. It returns the encoded body and the
+// matching value for the Content-Encoding header.
+func compressBody(body []byte, encodings string) ([]byte, string, error) {
+	applied := []string{}
+	for _, encoding := range strings.Split(encodings, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == encodingIdentity {
+			continue
+		}
+		var buffer bytes.Buffer
+		var writer io.WriteCloser
+		switch encoding {
+		case encodingGzip:
+			writer = gzip.NewWriter(&buffer)
+		case encodingDeflate:
+			// the "deflate" coding is the zlib format, see RFC 9110
+			writer = zlib.NewWriter(&buffer)
+		case encodingBrotli:
+			writer = brotli.NewWriter(&buffer)
+		case encodingZstd:
+			// only fails for invalid options
+			writer, _ = zstd.NewWriter(&buffer)
+		default:
+			return nil, "", fmt.Errorf("invalid compression '%s'", encoding)
+		}
+		if _, err := writer.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+		body = buffer.Bytes()
+		applied = append(applied, encoding)
+	}
+	return body, strings.Join(applied, ", "), nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/suite"
+)
+
+type compressionTestSuite struct {
+	suite.Suite
+}
+
+func TestCompressionTestSuite(t *testing.T) {
+	suite.Run(t, new(compressionTestSuite))
+}
+
+func (s *compressionTestSuite) decompress(body []byte, encoding string) []byte {
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case encodingGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case encodingDeflate:
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	case encodingBrotli:
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case encodingZstd:
+		reader, err = zstd.NewReader(bytes.NewReader(body))
+	}
+	s.Require().NoError(err)
+	decompressed, err := io.ReadAll(reader)
+	s.Require().NoError(err)
+	return decompressed
+}
+
+func (s *compressionTestSuite) TestCompressBody() {
+	body := []byte("<script>alert(1)</script>")
+	for _, encoding := range []string{encodingGzip, encodingDeflate, encodingBrotli, encodingZstd} {
+		compressed, contentEncoding, err := compressBody(body, encoding)
+		s.Require().NoError(err, encoding)
+		s.Equal(encoding, contentEncoding)
+		s.NotEqual(body, compressed)
+		s.Equal(body, s.decompress(compressed, encoding), encoding)
+	}
+}
+
+func (s *compressionTestSuite) TestCompressBody_Stacked() {
+	body := []byte("stacked")
+	compressed, contentEncoding, err := compressBody(body, " GZIP ,identity, br")
+	s.Require().NoError(err)
+	s.Equal("gzip, br", contentEncoding)
+	// decode in reverse order
+	s.Equal(body, s.decompress(s.decompress(compressed, encodingBrotli), encodingGzip))
+}
+
+func (s *compressionTestSuite) TestCompressBody_Invalid() {
+	_, _, err := compressBody([]byte("body"), "gzip, lzma")
+	s.EqualError(err, "invalid compression 'lzma'")
+}
+
+func (s *compressionTestSuite) TestValidateCompression() {
+	s.NoError(validateCompression(&reflectionSpec{}))
+	s.NoError(validateCompression(&reflectionSpec{Compress: " GZIP ,identity, br,deflate,zstd"}))
+	s.EqualError(validateCompression(&reflectionSpec{Compress: "gzip, lzma"}), "invalid compression 'lzma'")
+	s.EqualError(validateCompression(&reflectionSpec{Compress: "gzip,"}), "invalid compression ''")
+	s.EqualError(validateCompression(&reflectionSpec{EncodedChunks: []string{"YQ=="}, Compress: "gzip"}),
+		"compress can't be combined with chunks, use chunked instead")
+}
+
+func (s *compressionTestSuite) TestConfigure_InvalidCompression() {
+	server := httptest.NewServer(New().Handler())
+	s.T().Cleanup(server.Close)
+
+	for spec, field := range map[string]string{
+		`{"compress": "lzma"}`:                                        "compress",
+		`{"chunks": ["a"], "compress": "gzip"}`:                       "compress",
+		`{"responses": [{"compress": "gzip"}, {"compress": "lzma"}]}`: "responses[1].compress",
+	} {
+		response, body := doRequest(s.T(), http.DefaultClient, http.MethodPost, server.URL+"/configure_reflection", spec, nil)
+		s.Equal(http.StatusBadRequest, response.StatusCode, spec)
+		document := &errorDocument{}
+		s.Require().NoError(json.Unmarshal([]byte(body), document))
+		s.Equal(field, document.Field, spec)
+	}
+}
+
+func (s *compressionTestSuite) TestReflect() {
+	server := httptest.NewServer(New().Handler())
+	s.T().Cleanup(server.Close)
+	// don't let the client decompress transparently
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	reflect := func(spec *reflectionSpec) (*http.Response, []byte) {
+		body, err := json.Marshal(spec)
+		s.Require().NoError(err)
+		response, err := client.Post(server.URL+"/reflect", "application/json", bytes.NewReader(body))
+		s.Require().NoError(err)
+		defer response.Body.Close()
+		responseBody, err := io.ReadAll(response.Body)
+		s.Require().NoError(err)
+		return response, responseBody
+	}
+
+	response, body := reflect(&reflectionSpec{Body: "compressed", Compress: "zstd"})
+	s.Equal("zstd", response.Header.Get("Content-Encoding"))
+	s.Equal("compressed", string(s.decompress(body, encodingZstd)))
+
+	// mismatched encoding
+	response, body = reflect(&reflectionSpec{Body: "compressed", Compress: "gzip", ContentEncoding: "br"})
+	s.Equal("br", response.Header.Get("Content-Encoding"))
+	s.Equal("compressed", string(s.decompress(body, encodingGzip)))
+
+	// uncompressed body that claims to be compressed
+	response, body = reflect(&reflectionSpec{Body: "plain", ContentEncoding: "gzip"})
+	s.Equal("gzip", response.Header.Get("Content-Encoding"))
+	s.Equal("plain", string(body))
+
+	response, _ = reflect(&reflectionSpec{Chunks: []string{"a"}, Compress: "gzip"})
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+)
+
+const (
+	// maxEchoBodySize is the maximum number of body bytes echoed back.
+	maxEchoBodySize = 1024 * 1024
+
+	echoFormatJSON = "json"
+	echoFormatRaw  = "raw"
+)
+
+// echoResponse is the JSON rendering of a received request.
+type echoResponse struct {
+	Method   string        `json:"method"`
+	URI      string        `json:"uri"`
+	Path     string        `json:"path"`
+	Query    url.Values    `json:"query"`
+	Host     string        `json:"host"`
+	Protocol *protocolInfo `json:"protocol"`
+	TLS      *echoTLSInfo  `json:"tls,omitempty"`
+	// Headers are in wire order where possible, see requestHeaders.
+	Headers []headerField `json:"headers"`
+	// Body is set if the body is valid UTF-8, EncodedBody (base64) otherwise.
+	Body          string `json:"body,omitempty"`
+	EncodedBody   string `json:"encodedBody,omitempty"`
+	BodySize      int64  `json:"bodySize"`
+	BodyTruncated bool   `json:"bodyTruncated,omitempty"`
+	RemoteAddr    string `json:"remoteAddr"`
+}
+
+type echoTLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ServerName  string `json:"serverName,omitempty"`
+}
+
+func validateEchoFormat(format string) error {
+	switch format {
+	case "", echoFormatJSON, echoFormatRaw:
+		return nil
+	default:
+		return fmt.Errorf("invalid echo format '%s'", format)
+	}
+}
+
+// renderEcho renders the request in the given format. body holds the
+// (possibly partial) body of the request and bodySize the size of the
+// complete body. It returns the rendering and its content type.
+func renderEcho(r *http.Request, body []byte, bodySize int64, format string, pretty bool) ([]byte, string, error) {
+	if len(body) > maxEchoBodySize {
+		body = body[:maxEchoBodySize]
+	}
+	if err := validateEchoFormat(format); err != nil {
+		return nil, "", err
+	}
+	if format == echoFormatRaw {
+		return renderRawEcho(r, body), "message/http", nil
+	}
+
+	echo := &echoResponse{
+		Method:        r.Method,
+		URI:           r.RequestURI,
+		Path:          r.URL.Path,
+		Query:         r.URL.Query(),
+		Host:          r.Host,
+		Protocol:      getProtocolInfo(r),
+		Headers:       getRequestHeaders(r),
+		BodySize:      bodySize,
+		BodyTruncated: int64(len(body)) < bodySize,
+		RemoteAddr:    r.RemoteAddr,
+	}
+	if r.TLS != nil {
+		echo.TLS = &echoTLSInfo{
+			Version:     tls.VersionName(r.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:  r.TLS.ServerName,
+		}
+	}
+	if utf8.Valid(body) {
+		echo.Body = string(body)
+	} else {
+		echo.EncodedBody = base64.StdEncoding.EncodeToString(body)
+	}
+
+	var rendered []byte
+	var err error
+	if pretty {
+		rendered, err = json.MarshalIndent(echo, "", "  ")
+	} else {
+		rendered, err = json.Marshal(echo)
+	}
+	return rendered, "application/json", err
+}
+
+// renderRawEcho renders the request as an HTTP/1.x message.
+func renderRawEcho(r *http.Request, body []byte) []byte {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %s %s\r\n", r.Method, r.RequestURI, r.Proto)
+	for _, header := range getRequestHeaders(r) {
+		fmt.Fprintf(&buffer, "%s: %s\r\n", header.Name, header.Value)
+	}
+	buffer.WriteString("\r\n")
+	buffer.Write(body)
+	return buffer.Bytes()
+}
+
+func (a *Albedo) handleEcho(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received echo request")
+
+	body, bodySize, err := readBodyPrefix(r.Body, max(a.global.journal.bodyLimit, maxEchoBodySize))
+	if err != nil {
+		a.log().Warn("Failed to read request body", "error", err.Error())
+	}
+	a.recordRequest(r, body, bodySize, nil)
+
+	query := r.URL.Query()
+	echo, contentType, err := renderEcho(r, body, bodySize, query.Get("format"), query.Get("pretty") == "true")
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, nestField("format", err))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if _, err = w.Write(echo); err != nil {
+		a.log().Warn("Failed to write response body", "error", err.Error())
+	}
+}
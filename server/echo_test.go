@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type echoTestSuite struct {
+	serverSuite
+}
+
+func TestEchoTestSuite(t *testing.T) {
+	suite.Run(t, new(echoTestSuite))
+}
+
+func (s *echoTestSuite) SetupTest() {
+	s.serveWire()
+}
+
+// send writes the raw request and returns the parsed response.
+func (s *echoTestSuite) send(request string) (*http.Response, []byte) {
+	conn := s.dial()
+	_, err := conn.Write([]byte(request))
+	s.Require().NoError(err)
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	s.Require().NoError(err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	s.Require().NoError(err)
+	return response, body
+}
+
+func (s *echoTestSuite) TestEcho() {
+	response, body := s.send("POST /echo?a=1&a=2 HTTP/1.1\r\nHost: example.com\r\nX-B: 1\r\nX-A: 2\r\nX-B: 3\r\nContent-Length: 5\r\n\r\nhello")
+
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("application/json", response.Header.Get("Content-Type"))
+	echo := &echoResponse{}
+	s.Require().NoError(json.Unmarshal(body, echo))
+	s.Equal("POST", echo.Method)
+	s.Equal("/echo?a=1&a=2", echo.URI)
+	s.Equal("/echo", echo.Path)
+	s.Equal([]string{"1", "2"}, echo.Query["a"])
+	s.Equal("example.com", echo.Host)
+	s.Equal("HTTP/1.1", echo.Protocol.Proto)
+	s.Nil(echo.TLS)
+	s.Equal([]headerField{
+		{Name: "Host", Value: "example.com"},
+		{Name: "X-B", Value: "1"},
+		{Name: "X-A", Value: "2"},
+		{Name: "X-B", Value: "3"},
+		{Name: "Content-Length", Value: "5"},
+	}, echo.Headers)
+	s.Equal("hello", echo.Body)
+	s.Equal(int64(5), echo.BodySize)
+	s.NotEmpty(echo.RemoteAddr)
+
+	entries := s.albedo.global.journal.query(&journalFilter{})
+	s.Require().Len(entries, 1)
+	s.Equal("/echo?a=1&a=2", entries[0].URI)
+}
+
+func (s *echoTestSuite) TestEcho_BinaryBody() {
+	_, body := s.send("POST /echo HTTP/1.1\r\nHost: example.com\r\nContent-Length: 2\r\n\r\n\xff\xfe")
+
+	echo := &echoResponse{}
+	s.Require().NoError(json.Unmarshal(body, echo))
+	s.Empty(echo.Body)
+	s.Equal("//4=", echo.EncodedBody)
+}
+
+func (s *echoTestSuite) TestEcho_Raw() {
+	response, body := s.send("GET /echo?format=raw HTTP/1.1\r\nHost: example.com\r\nX-Test: a\r\n\r\n")
+
+	s.Equal("message/http", response.Header.Get("Content-Type"))
+	s.Equal("GET /echo?format=raw HTTP/1.1\r\nHost: example.com\r\nX-Test: a\r\n\r\n", string(body))
+}
+
+func (s *echoTestSuite) TestEcho_InvalidFormat() {
+	response, _ := s.send("GET /echo?format=xml HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
+
+func (s *echoTestSuite) TestEcho_InvalidFormat_Configuration() {
+	response, body := s.do("POST", "/configure_reflection",
+		`{"echo": true, "echoFormat": "xml", "endpoints": [{"method": "GET", "url": "/mirror"}]}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "invalid_specification", "message": "invalid echo format 'xml'", "field": "echoFormat"}`, body)
+}
+
+func (s *echoTestSuite) TestEcho_TLS() {
+	server := httptest.NewTLSServer(s.albedo.Handler())
+	s.T().Cleanup(server.Close)
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	_, body := doRequest(s.T(), &client, "GET", server.URL+"/echo", "", nil)
+	echo := &echoResponse{}
+	s.Require().NoError(json.Unmarshal([]byte(body), echo))
+	s.Require().NotNil(echo.TLS)
+	s.NotEmpty(echo.TLS.Version)
+	s.NotEmpty(echo.TLS.CipherSuite)
+	s.True(echo.Protocol.TLS)
+}
+
+func (s *echoTestSuite) TestEcho_ConfiguredEndpoint() {
+	spec := &configureReflectionSpec{
+		reflectionSpec: reflectionSpec{
+			Status:     http.StatusCreated,
+			Headers:    map[string]string{"X-Echo": "true"},
+			Body:       "ignored",
+			Echo:       true,
+			EchoFormat: echoFormatRaw,
+		},
+		Endpoints: []dynamicEndpointSpec{{Method: "PUT", Url: "/mirror"}},
+	}
+	s.Require().NoError(s.albedo.global.endpoints.configure(spec))
+
+	response, body := s.send("PUT /mirror HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\n\r\nbody")
+	s.Equal(http.StatusCreated, response.StatusCode)
+	s.Equal("true", response.Header.Get("X-Echo"))
+	s.Equal("message/http", response.Header.Get("Content-Type"))
+	s.Equal("PUT /mirror HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\n\r\nbody", string(body))
+}
+
+func (s *echoTestSuite) TestReflect_Echo() {
+	spec, err := json.Marshal(&reflectionSpec{Echo: true})
+	s.Require().NoError(err)
+	_, body := s.do("POST", "/reflect", string(spec))
+
+	echo := &echoResponse{}
+	s.Require().NoError(json.Unmarshal([]byte(body), echo))
+	s.Equal("/reflect", echo.Path)
+	s.Equal(string(spec), echo.Body)
+}
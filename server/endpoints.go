@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func (a *Albedo) handleGetEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a.log().Info(fmt.Sprintf("Received request for endpoint %s", id))
+
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	endpoint, ok := ns.endpoints.get(id)
+	if !ok {
+		a.writeEndpointError(w, errEndpointNotFound)
+		return
+	}
+	a.writeEndpointDetails(w, r, endpoint.details())
+}
+
+func (a *Albedo) handleDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a.log().Info(fmt.Sprintf("Received request to delete endpoint %s", id))
+
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	endpoint, ok := ns.endpoints.remove(id)
+	if !ok {
+		a.writeEndpointError(w, errEndpointNotFound)
+		return
+	}
+	a.persistEndpoints(ns)
+	a.writeEndpointDetails(w, r, endpoint.details())
+}
+
+// handlePatchEndpoint applies a JSON merge patch (RFC 7396) to the
+// configuration of an endpoint, e.g., {"status": 503} or
+// {"endpoints": [{"method": "POST", "url": "/login"}]}.
+func (a *Albedo) handlePatchEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a.log().Info(fmt.Sprintf("Received request to update endpoint %s", id))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidBody, fmt.Errorf("Failed to parse request body: %w", err))
+		return
+	}
+	var patch any
+	if err = json.Unmarshal(body, &patch); err != nil {
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidJSON, fmt.Errorf("Invalid JSON in request body: %w", err))
+		return
+	}
+
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	endpoint, err := ns.endpoints.update(id, func(spec configureReflectionSpec) (*configureReflectionSpec, error) {
+		return applyMergePatch(&spec, patch)
+	})
+	if err != nil {
+		a.writeEndpointError(w, err)
+		return
+	}
+	a.persistEndpoints(ns)
+	a.writeEndpointDetails(w, r, endpoint.details())
+}
+
+// applyMergePatch returns a copy of the specification with the merge patch
+// applied.
+func applyMergePatch(spec *configureReflectionSpec, patch any) (*configureReflectionSpec, error) {
+	// marshalling can't fail for the specification's types
+	current, _ := json.Marshal(spec)
+	var document any
+	if err := json.Unmarshal(current, &document); err != nil {
+		return nil, err
+	}
+	patched, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	result := &configureReflectionSpec{}
+	if err = decoder.Decode(result); err != nil {
+		// offsets refer to the patched document, not to the request body
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fieldErrorf(typeErr.Field, "invalid patch: %v", err)
+		}
+		return nil, fmt.Errorf("invalid patch: %v", err)
+	}
+	return result, nil
+}
+
+// mergePatch implements the JSON merge patch algorithm of RFC 7396.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+func (a *Albedo) writeEndpointDetails(w http.ResponseWriter, r *http.Request, details *endpointDetails) {
+	w.Header().Add("Content-Type", "application/json")
+	var body []byte
+	var err error
+	if r.URL.Query().Get("pretty") == "true" {
+		body, err = json.MarshalIndent(details, "", "  ")
+	} else {
+		body, err = json.Marshal(details)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, errorCodeInternal, fmt.Errorf("Failed to marshal endpoint: %w", err))
+		return
+	}
+	if _, err = w.Write(body); err != nil {
+		a.log().Warn("Failed to write response body", "error", err.Error())
+	}
+}
+
+func (a *Albedo) writeEndpointError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEndpointNotFound):
+		a.writeError(w, http.StatusNotFound, errorCodeNotFound, err)
+	case errors.Is(err, errEndpointConflict):
+		a.writeError(w, http.StatusConflict, errorCodeConflict, err)
+	default:
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidSpecification, err)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type endpointsTestSuite struct {
+	serverSuite
+}
+
+func TestEndpointsTestSuite(t *testing.T) {
+	suite.Run(t, new(endpointsTestSuite))
+}
+
+func (s *endpointsTestSuite) SetupTest() {
+	s.serve()
+	s.Require().NoError(s.albedo.global.endpoints.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 201, Body: "created"},
+		Endpoints: []dynamicEndpointSpec{
+			{Method: "POST", Url: "/users"},
+			{Method: "GET", Url: "/users/{id}", Match: matchPattern},
+		},
+	}))
+}
+
+// id returns the ID of the endpoint with the given URL.
+func (s *endpointsTestSuite) id(url string) string {
+	for _, status := range s.albedo.global.endpoints.list() {
+		if status.Url == url {
+			return status.ID
+		}
+	}
+	s.FailNow("endpoint not found", url)
+	return ""
+}
+
+func (s *endpointsTestSuite) TestGet() {
+	s.do("GET", "/users/1", "")
+	s.do("GET", "/users/2", "")
+
+	response, body := s.do("GET", "/endpoints/"+s.id("/users/{id}"), "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("application/json", response.Header.Get("Content-Type"))
+	details := &endpointDetails{}
+	s.Require().NoError(json.Unmarshal([]byte(body), details))
+	s.Equal(s.id("/users/{id}"), details.ID)
+	s.Equal(uint64(2), details.Hits)
+	s.False(details.Created.IsZero())
+	s.Equal(201, details.Configuration.Status)
+	s.Equal("created", details.Configuration.Body)
+	s.Equal([]dynamicEndpointSpec{{Method: "GET", Url: "/users/{id}", Match: matchPattern}}, details.Configuration.Endpoints)
+}
+
+func (s *endpointsTestSuite) TestGet_NotFound() {
+	for _, id := range []string{"0000000000000000", "nope"} {
+		response, _ := s.do("GET", "/endpoints/"+id, "")
+		s.Equal(http.StatusNotFound, response.StatusCode)
+	}
+}
+
+func (s *endpointsTestSuite) TestDelete() {
+	id := s.id("/users")
+	s.do("POST", "/users", "")
+	response, body := s.do("DELETE", "/endpoints/"+id, "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	details := &endpointDetails{}
+	s.Require().NoError(json.Unmarshal([]byte(body), details))
+	s.Equal(id, details.ID)
+	s.Equal(uint64(1), details.Hits)
+	s.False(details.Created.IsZero())
+	s.Equal([]dynamicEndpointSpec{{Method: "POST", Url: "/users"}}, details.Configuration.Endpoints)
+
+	response, _ = s.do("POST", "/users", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	response, _ = s.do("GET", "/users/1", "")
+	s.Equal(http.StatusCreated, response.StatusCode)
+	s.Len(s.albedo.global.endpoints.list(), 1)
+
+	response, _ = s.do("DELETE", "/endpoints/"+id, "")
+	s.Equal(http.StatusNotFound, response.StatusCode)
+}
+
+func (s *endpointsTestSuite) TestPatch() {
+	id := s.id("/users")
+	s.do("POST", "/users", "")
+
+	response, body := s.do("PATCH", "/endpoints/"+id, `{"status": 503, "body": null, "headers": {"Retry-After": "1"}}`)
+	s.Require().Equal(http.StatusOK, response.StatusCode, body)
+	details := &endpointDetails{}
+	s.Require().NoError(json.Unmarshal([]byte(body), details))
+	s.Equal(id, details.ID)
+	s.Equal(uint64(1), details.Hits)
+	s.Equal(503, details.Configuration.Status)
+	s.Empty(details.Configuration.Body)
+
+	response, body = s.do("POST", "/users", "")
+	s.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	s.Equal("1", response.Header.Get("Retry-After"))
+	s.Empty(body)
+}
+
+func (s *endpointsTestSuite) TestPatch_Move() {
+	id := s.id("/users")
+	response, body := s.do("PATCH", "/endpoints/"+id, `{"endpoints": [{"method": "PUT", "url": "/accounts"}]}`)
+	s.Require().Equal(http.StatusOK, response.StatusCode, body)
+	details := &endpointDetails{}
+	s.Require().NoError(json.Unmarshal([]byte(body), details))
+	s.NotEqual(id, details.ID)
+	s.Equal(details.ID, s.id("/accounts"))
+
+	response, _ = s.do("PUT", "/accounts", "")
+	s.Equal(http.StatusCreated, response.StatusCode)
+	response, _ = s.do("POST", "/users", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (s *endpointsTestSuite) TestPatch_Invalid() {
+	id := s.id("/users")
+	for patch, status := range map[string]int{
+		`{`:                      http.StatusBadRequest,
+		`{"stauts": 1}`:          http.StatusBadRequest,
+		`{"afterLast": "never"}`: http.StatusBadRequest,
+		`{"endpoints": []}`:      http.StatusBadRequest,
+		`{"endpoints": [{"method": "GET", "url": "/users/{id}", "match": "pattern"}]}`: http.StatusConflict,
+	} {
+		response, _ := s.do("PATCH", "/endpoints/"+id, patch)
+		s.Equal(status, response.StatusCode, patch)
+	}
+	response, _ := s.do("POST", "/users", "")
+	s.Equal(http.StatusCreated, response.StatusCode)
+}
+
+func (s *endpointsTestSuite) TestMergePatch() {
+	target := map[string]any{"a": "b", "c": map[string]any{"d": "e", "f": "g"}}
+	patch := map[string]any{"a": "z", "c": map[string]any{"f": nil}, "h": []any{1.0}}
+	s.Equal(map[string]any{"a": "z", "c": map[string]any{"d": "e"}, "h": []any{1.0}}, mergePatch(target, patch))
+	s.Equal([]any{"x"}, mergePatch(target, []any{"x"}))
+}
+
+func (s *endpointsTestSuite) TestPersistence() {
+	path := filepath.Join(s.T().TempDir(), "endpoints.json")
+	s.albedo.endpointsFile = &endpointsFile{path: path, persist: true}
+
+	s.do("DELETE", "/endpoints/"+s.id("/users"), "")
+	content, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	specs, err := parseEndpointsFile(content)
+	s.Require().NoError(err)
+	s.Require().Len(specs, 1)
+	s.Equal("/users/{id}", specs[0].Endpoints[0].Url)
+
+	s.do("PATCH", "/endpoints/"+s.id("/users/{id}"), `{"status": 202}`)
+	content, err = os.ReadFile(path)
+	s.Require().NoError(err)
+	specs, err = parseEndpointsFile(content)
+	s.Require().NoError(err)
+	s.Equal(202, specs[0].Status)
+}
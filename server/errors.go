@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// errorHeader marks responses that carry an errorDocument, so that clients
+// can tell albedo's own errors apart from reflected responses. Its value is
+// the error code.
+const errorHeader = "X-Albedo-Error"
+
+// Error codes of errorDocument.
+const (
+	// errorCodeInvalidBody signals that the request body couldn't be read
+	errorCodeInvalidBody = "invalid_body"
+	// errorCodeInvalidJSON signals that the request body isn't valid JSON or
+	// doesn't match the expected document
+	errorCodeInvalidJSON = "invalid_json"
+	// errorCodeInvalidSpecification signals that a specification is
+	// semantically invalid
+	errorCodeInvalidSpecification = "invalid_specification"
+	// errorCodeInvalidParameter signals an invalid query parameter
+	errorCodeInvalidParameter = "invalid_parameter"
+	// errorCodeInvalidNamespace signals an invalid namespace name
+	errorCodeInvalidNamespace = "invalid_namespace"
+	// errorCodeTooManyNamespaces signals that a namespace couldn't be created
+	errorCodeTooManyNamespaces = "too_many_namespaces"
+	// errorCodeUnauthorized signals a missing or invalid admin token
+	errorCodeUnauthorized = "unauthorized"
+	// errorCodeNotFound signals that the requested endpoint doesn't exist
+	errorCodeNotFound = "not_found"
+	// errorCodeConflict signals that a change would collide with another
+	// endpoint
+	errorCodeConflict = "conflict"
+	// errorCodeUnsupported signals a feature that isn't available for the
+	// request, e.g., raw responses over HTTP/2
+	errorCodeUnsupported = "unsupported"
+	// errorCodeShuttingDown signals that a request was aborted because the
+	// server is shutting down
+	errorCodeShuttingDown = "shutting_down"
+	// errorCodeInternal signals an unexpected failure in albedo
+	errorCodeInternal = "internal_error"
+)
+
+// errorDocument is the body of responses to failed requests to the built-in
+// endpoints.
+type errorDocument struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Field is the offending field of the specification or the offending
+	// query parameter, if known
+	Field string `json:"field,omitempty"`
+	// Offset is the byte offset in the request body at which JSON decoding
+	// failed
+	Offset *int64 `json:"offset,omitempty"`
+}
+
+// fieldError attributes an error to a field of a specification, e.g.,
+// "responses[1].status".
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+func fieldErrorf(field string, format string, args ...any) error {
+	return &fieldError{field: field, err: fmt.Errorf(format, args...)}
+}
+
+// nestField attributes err to the given field. If err is already attributed
+// to a field, that field is treated as relative to the given field.
+func nestField(field string, err error) error {
+	var inner *fieldError
+	if errors.As(err, &inner) {
+		if strings.HasPrefix(inner.field, "[") {
+			field += inner.field
+		} else {
+			field += "." + inner.field
+		}
+	}
+	return &fieldError{field: field, err: err}
+}
+
+// writeError responds with an errorDocument describing err. The field and
+// the offset are taken from err if it is a fieldError or a JSON decoding
+// error.
+func (a *Albedo) writeError(w http.ResponseWriter, status int, code string, err error) {
+	document := &errorDocument{Code: code, Message: err.Error()}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		document.Offset = &syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		document.Field = typeErr.Field
+		document.Offset = &typeErr.Offset
+	}
+	var fieldErr *fieldError
+	if errors.As(err, &fieldErr) {
+		document.Field = fieldErr.field
+	}
+
+	// marshalling can't fail for the document's types
+	body, _ := json.Marshal(document)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(errorHeader, code)
+	w.WriteHeader(status)
+	if _, writeErr := w.Write(body); writeErr != nil {
+		a.log().Warn("Failed to write response body", "error", writeErr.Error())
+	}
+	a.log().Info(err.Error(), "code", code)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type errorsTestSuite struct {
+	serverSuite
+}
+
+func TestErrorsTestSuite(t *testing.T) {
+	suite.Run(t, new(errorsTestSuite))
+}
+
+func (s *errorsTestSuite) SetupTest() {
+	s.serve()
+}
+
+func (s *errorsTestSuite) TestSyntaxError() {
+	response, body := s.do("POST", "/reflect", `{"status": 200,}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.Equal("application/json", response.Header.Get("Content-Type"))
+	s.Equal(errorCodeInvalidJSON, response.Header.Get(errorHeader))
+	s.JSONEq(`{
+		"code": "invalid_json",
+		"message": "Invalid JSON in request body: invalid character '}' looking for beginning of object key string",
+		"offset": 16
+	}`, body)
+}
+
+func (s *errorsTestSuite) TestTypeError() {
+	response, body := s.do("POST", "/configure_reflection", `{"status": "200", "endpoints": []}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.Equal(errorCodeInvalidJSON, response.Header.Get(errorHeader))
+	s.Contains(body, `"field":"status"`)
+	s.Contains(body, `"offset":16`)
+}
+
+func (s *errorsTestSuite) TestInvalidStatus() {
+	response, body := s.do("POST", "/reflect", `{"status": 1000, "headers": {"X-Reflected": "yes"}}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.Equal(errorCodeInvalidSpecification, response.Header.Get(errorHeader))
+	s.Empty(response.Header.Get("X-Reflected"))
+	s.JSONEq(`{"code": "invalid_specification", "message": "Invalid status code: 1000", "field": "status"}`, body)
+}
+
+func (s *errorsTestSuite) TestReflectedStatusIsNotMarked() {
+	response, _ := s.do("POST", "/reflect", `{"status": 400, "body": "reflected"}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.Empty(response.Header.Get(errorHeader))
+}
+
+func (s *errorsTestSuite) TestNestedFields() {
+	response, body := s.do("POST", "/configure_reflection", `{
+		"responses": [{"status": 200}, {"template": true, "body": "{{ .Method"}],
+		"endpoints": [{"method": "GET", "url": "/a"}]
+	}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.Contains(body, `"field":"responses[1].body"`)
+
+	_, body = s.do("POST", "/configure_reflection", `{
+		"endpoints": [
+			{"method": "GET", "url": "/a"},
+			{"method": "GET", "url": "/b", "headers": [{"name": "X-Test", "regex": "("}]}
+		]
+	}`)
+	s.Contains(body, `"field":"endpoints[1].headers[0].regex"`)
+}
+
+func (s *errorsTestSuite) TestNotFound() {
+	response, body := s.do("GET", "/endpoints/0000000000000000", "")
+	s.Equal(http.StatusNotFound, response.StatusCode)
+	s.Equal(errorCodeNotFound, response.Header.Get(errorHeader))
+	s.JSONEq(`{"code": "not_found", "message": "endpoint not found"}`, body)
+}
+
+func (s *errorsTestSuite) TestInvalidParameter() {
+	response, body := s.do("GET", "/journal?limit=-1", "")
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "invalid_parameter", "message": "invalid limit '-1'", "field": "limit"}`, body)
+}
+
+func (s *errorsTestSuite) TestNestField() {
+	err := nestField("responses[0]", nestField("[1]", fieldErrorf("regex", "invalid")))
+	fieldErr := &fieldError{}
+	s.Require().ErrorAs(err, &fieldErr)
+	s.Equal("responses[0][1].regex", fieldErr.field)
+	s.EqualError(err, "invalid")
+
+	fieldErr = &fieldError{}
+	s.Require().ErrorAs(nestField("body", errors.New("plain")), &fieldErr)
+	s.Equal("body", fieldErr.field)
+}
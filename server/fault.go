@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// faultClose closes the connection before sending anything
+	faultClose = "close"
+	// faultReset sends the status line and headers, then resets the
+	// connection
+	faultReset = "reset"
+	// faultTruncate declares a Content-Length larger than the body
+	faultTruncate = "truncate"
+	// faultHang doesn't respond until the client gives up or the server
+	// shuts down
+	faultHang = "hang"
+)
+
+func validateFault(fault *faultSpec, bodySize int) error {
+	if err := validateFaultMode(fault); err != nil {
+		return err
+	}
+	if fault.Mode == faultTruncate && fault.ContentLength != 0 && fault.ContentLength <= bodySize {
+		return fieldErrorf("fault.contentLength", "fault content length %d must be larger than the body size %d", fault.ContentLength, bodySize)
+	}
+	return nil
+}
+
+// validateFaultMode validates the fault independently of the response body,
+// whose size may only be known once the response is rendered.
+func validateFaultMode(fault *faultSpec) error {
+	switch fault.Mode {
+	case faultClose, faultReset, faultHang, faultTruncate:
+	default:
+		return fieldErrorf("fault.mode", "invalid fault mode '%s'", fault.Mode)
+	}
+	if fault.ContentLength < 0 {
+		return fieldErrorf("fault.contentLength", "invalid fault content length %d", fault.ContentLength)
+	}
+	return nil
+}
+
+// injectFault injects the fault of the response. It returns true if the
+// response must not be written.
+func (a *Albedo) injectFault(w http.ResponseWriter, r *http.Request, response *reflection) bool {
+	switch response.fault.Mode {
+	case faultClose:
+		a.log().Info("Injecting fault: closing connection")
+		a.abortConnection(w, false)
+	case faultReset:
+		a.log().Info("Injecting fault: resetting connection after headers")
+		w.WriteHeader(response.status)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			a.log().Warn("Failed to flush response headers", "error", err.Error())
+		}
+		a.abortConnection(w, true)
+	case faultHang:
+		a.log().Info("Injecting fault: hanging until the client gives up")
+		select {
+		case <-r.Context().Done():
+			a.log().Info("Client gave up on hanging request")
+		case <-shuttingDown(r):
+			// don't hold up a graceful shutdown
+			a.log().Info("Closing hanging request on shutdown")
+			a.abortConnection(w, false)
+		}
+	case faultTruncate:
+		contentLength := response.fault.ContentLength
+		if contentLength == 0 {
+			contentLength = response.bodySize() + 1
+		}
+		a.log().Info(fmt.Sprintf("Injecting fault: declaring Content-Length %d for body of %d bytes", contentLength, response.bodySize()))
+		w.Header().Set("Content-Length", strconv.Itoa(contentLength))
+		return false
+	}
+	return true
+}
+
+// abortConnection closes the connection of the response without writing
+// anything else. If reset is true, the connection is reset instead of being
+// closed gracefully. Connections that can't be hijacked, i.e., HTTP/2
+// connections, have their stream reset instead.
+func (a *Albedo) abortConnection(w http.ResponseWriter, reset bool) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// makes net/http reset the stream
+		panic(http.ErrAbortHandler)
+	}
+	if reset {
+		resetOnClose(conn)
+	}
+	if err := conn.Close(); err != nil {
+		a.log().Warn("Failed to close connection", "error", err.Error())
+	}
+}
+
+// resetOnClose makes closing the connection send a TCP RST instead of a FIN.
+func resetOnClose(conn net.Conn) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			_ = c.SetLinger(0)
+			return
+		case *wireRecorder:
+			conn = c.Conn
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type faultTestSuite struct {
+	serverSuite
+}
+
+func TestFaultTestSuite(t *testing.T) {
+	suite.Run(t, new(faultTestSuite))
+}
+
+func (s *faultTestSuite) SetupTest() {
+	s.serve()
+}
+
+// sendRaw sends a reflection request and returns everything read from the
+// connection, along with the read error.
+func (s *faultTestSuite) sendRaw(spec *reflectionSpec) (string, error) {
+	body, err := json.Marshal(spec)
+	s.Require().NoError(err)
+	conn := s.dial()
+
+	request, err := http.NewRequest(http.MethodPost, s.server.URL+"/reflect", bytes.NewReader(body))
+	s.Require().NoError(err)
+	s.Require().NoError(request.Write(conn))
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	response, err := io.ReadAll(conn)
+	return string(response), err
+}
+
+// reflect sends a reflection request with the given client, leaving the
+// response body to be read by the caller.
+func (s *faultTestSuite) reflect(ctx context.Context, client *http.Client, url string, spec *reflectionSpec) (*http.Response, error) {
+	body, err := json.Marshal(spec)
+	s.Require().NoError(err)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/reflect", bytes.NewReader(body))
+	s.Require().NoError(err)
+	return client.Do(request)
+}
+
+func (s *faultTestSuite) TestClose() {
+	response, err := s.sendRaw(&reflectionSpec{Body: "never sent", Fault: &faultSpec{Mode: faultClose}})
+	s.NoError(err)
+	s.Empty(response)
+}
+
+func (s *faultTestSuite) TestReset() {
+	response, err := s.sendRaw(&reflectionSpec{
+		Status:  http.StatusServiceUnavailable,
+		Headers: map[string]string{"X-Fault": "reset"},
+		Body:    "never sent",
+		Fault:   &faultSpec{Mode: faultReset},
+	})
+	s.ErrorIs(err, syscall.ECONNRESET)
+	s.Contains(response, "HTTP/1.1 503 Service Unavailable\r\n")
+	s.Contains(response, "X-Fault: reset\r\n")
+	s.NotContains(response, "never sent")
+}
+
+func (s *faultTestSuite) TestTruncate() {
+	response, err := s.reflect(context.Background(), http.DefaultClient, s.server.URL, &reflectionSpec{
+		Body:  "short",
+		Fault: &faultSpec{Mode: faultTruncate, ContentLength: 100},
+	})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+
+	s.Equal(int64(100), response.ContentLength)
+	body, err := io.ReadAll(response.Body)
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+	s.Equal("short", string(body))
+}
+
+func (s *faultTestSuite) TestTruncate_DefaultLength() {
+	response, err := s.reflect(context.Background(), http.DefaultClient, s.server.URL, &reflectionSpec{
+		Chunks: []string{"ab", "c"},
+		Fault:  &faultSpec{Mode: faultTruncate},
+	})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+
+	s.Empty(response.TransferEncoding)
+	s.Equal(int64(4), response.ContentLength)
+	_, err = io.ReadAll(response.Body)
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+}
+
+func (s *faultTestSuite) TestHang() {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := s.reflect(ctx, http.DefaultClient, s.server.URL, &reflectionSpec{Fault: &faultSpec{Mode: faultHang}})
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (s *faultTestSuite) TestCloseHTTP2() {
+	server := httptest.NewUnstartedServer(s.albedo.Handler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	s.T().Cleanup(server.Close)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	_, err := s.reflect(context.Background(), client, server.URL, &reflectionSpec{Fault: &faultSpec{Mode: faultClose}})
+	s.ErrorContains(err, "stream error")
+
+	// the connection survives the stream reset
+	response, err := s.reflect(context.Background(), client, server.URL, &reflectionSpec{Status: http.StatusAccepted})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+	s.Equal(http.StatusAccepted, response.StatusCode)
+}
+
+func (s *faultTestSuite) TestInvalidFault() {
+	for _, fault := range []faultSpec{
+		{Mode: "explode"},
+		{Mode: faultTruncate, ContentLength: 3},
+	} {
+		response, _ := s.doJSON("POST", "/reflect", &reflectionSpec{Body: "body", Fault: &fault})
+		s.Equal(http.StatusBadRequest, response.StatusCode, fault)
+	}
+}
+
+func (s *faultTestSuite) TestInvalidFault_Configuration() {
+	for field, spec := range map[string]string{
+		"fault.mode":              `{"fault": {"mode": "explode"}}`,
+		"fault.contentLength":     `{"fault": {"mode": "truncate", "contentLength": -1}}`,
+		"responses[1].fault.mode": `{"responses": [{"status": 200}, {"fault": {"mode": "explode"}}]}`,
+	} {
+		response, body := s.do("POST", "/configure_reflection", spec)
+		s.Equal(http.StatusBadRequest, response.StatusCode, spec)
+		document := &errorDocument{}
+		s.Require().NoError(json.Unmarshal([]byte(body), document))
+		s.Equal(field, document.Field, spec)
+	}
+}
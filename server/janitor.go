@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// janitorInterval is the interval at which expired and used up endpoints are
+// evicted.
+const janitorInterval = time.Second
+
+// runJanitor periodically evicts expired and used up endpoints from all
+// namespaces, until ctx is canceled.
+func (a *Albedo) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.evictEndpoints(now)
+		}
+	}
+}
+
+// evictEndpoints removes the endpoints that expired or have been used up at
+// the given time and returns the number of evicted endpoints.
+func (a *Albedo) evictEndpoints(now time.Time) int {
+	count := 0
+	for _, ns := range a.allNamespaces() {
+		count += a.evictNamespaceEndpoints(ns, now)
+	}
+	return count
+}
+
+// evictNamespaceEndpoints removes the endpoints of the namespace that
+// expired or have been used up at the given time and returns their number.
+// Besides the janitor, requests to the built-in endpoints evict endpoints of
+// their namespace, so that instances that aren't served by Serve don't list
+// or retain unavailable endpoints.
+func (a *Albedo) evictNamespaceEndpoints(ns *namespace, now time.Time) int {
+	evicted := ns.endpoints.evict(now)
+	for _, endpoint := range evicted {
+		reason := "max hits reached"
+		if !endpoint.expires.IsZero() && !now.Before(endpoint.expires) {
+			reason = "expired"
+		}
+		a.log().Info("Evicted endpoint",
+			"id", endpoint.id,
+			"method", endpoint.endpoint.Method,
+			"url", endpoint.endpoint.Url,
+			"namespace", ns.name,
+			"reason", reason)
+	}
+	if len(evicted) > 0 {
+		a.persistEndpoints(ns)
+	}
+	return len(evicted)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type janitorTestSuite struct {
+	serverSuite
+}
+
+func TestJanitorTestSuite(t *testing.T) {
+	suite.Run(t, new(janitorTestSuite))
+}
+
+func (s *janitorTestSuite) SetupTest() {
+	s.serve()
+}
+
+func (s *janitorTestSuite) configure(spec string) *http.Response {
+	response, _ := s.do("POST", "/configure_reflection", spec)
+	return response
+}
+
+func (s *janitorTestSuite) status(path string) int {
+	response, _ := s.do("GET", path, "")
+	return response.StatusCode
+}
+
+func (s *janitorTestSuite) endpoints() []endpointStatus {
+	_, body := s.do("GET", "/endpoints", "")
+	spec := &endpointsSpec{}
+	s.Require().NoError(json.Unmarshal([]byte(body), spec))
+	return spec.Endpoints
+}
+
+func (s *janitorTestSuite) TestMaxHits() {
+	response := s.configure(`{"status": 201, "maxHits": 2, "endpoints": [{"method": "GET", "url": "/once"}]}`)
+	s.Require().Equal(http.StatusOK, response.StatusCode)
+
+	endpoints := s.endpoints()
+	s.Require().Len(endpoints, 1)
+	s.Equal(uint64(2), endpoints[0].MaxHits)
+	s.Require().NotNil(endpoints[0].RemainingHits)
+	s.Equal(uint64(2), *endpoints[0].RemainingHits)
+	s.Nil(endpoints[0].Expires)
+
+	s.Equal(201, s.status("/once"))
+	s.Equal(uint64(1), *s.endpoints()[0].RemainingHits)
+	s.Equal(201, s.status("/once"))
+	s.Equal(200, s.status("/once"))
+
+	// requests to the built-in endpoints evict used up endpoints, even
+	// without the janitor
+	s.Empty(s.endpoints())
+	s.Equal(0, s.albedo.evictEndpoints(time.Now()))
+}
+
+func (s *janitorTestSuite) TestMaxHits_FallThrough() {
+	s.configure(`{"status": 201, "maxHits": 1, "endpoints": [{"method": "GET", "url": "/items/", "match": "prefix"}]}`)
+	s.configure(`{"status": 202, "endpoints": [{"method": "GET", "url": "/items/*", "match": "glob"}]}`)
+
+	s.Equal(201, s.status("/items/a"))
+	s.Equal(202, s.status("/items/a"))
+}
+
+func (s *janitorTestSuite) TestExpiresAfter() {
+	s.configure(`{"status": 201, "expiresAfter": "1h", "endpoints": [{"method": "GET", "url": "/short"}]}`)
+	s.configure(`{"status": 202, "endpoints": [{"method": "GET", "url": "/long"}]}`)
+
+	endpoints := s.endpoints()
+	s.Require().Len(endpoints, 2)
+	s.Nil(endpoints[0].Expires)
+	s.Require().NotNil(endpoints[1].Expires)
+	s.WithinDuration(time.Now().Add(time.Hour), *endpoints[1].Expires, time.Minute)
+	s.Nil(endpoints[1].RemainingHits)
+	s.Equal(201, s.status("/short"))
+
+	s.Equal(0, s.albedo.evictEndpoints(time.Now()))
+	s.Equal(1, s.albedo.evictEndpoints(time.Now().Add(time.Hour)))
+	s.Equal(200, s.status("/short"))
+	s.Equal(202, s.status("/long"))
+	s.Len(s.endpoints(), 1)
+}
+
+func (s *janitorTestSuite) TestExpiresAfter_Lookup() {
+	s.configure(`{"status": 201, "expiresAfter": "10ms", "endpoints": [{"method": "GET", "url": "/short"}]}`)
+	s.Equal(201, s.status("/short"))
+	time.Sleep(20 * time.Millisecond)
+	// expired endpoints don't respond even before they are evicted
+	s.Equal(200, s.status("/short"))
+	s.Empty(s.endpoints())
+}
+
+func (s *janitorTestSuite) TestExpiresAfter_Invalid() {
+	for _, value := range []string{"soon", "-1s", "0s"} {
+		response := s.configure(`{"status": 201, "expiresAfter": "` + value + `", "endpoints": [{"method": "GET", "url": "/a"}]}`)
+		s.Equal(http.StatusBadRequest, response.StatusCode, value)
+	}
+}
+
+func (s *janitorTestSuite) TestNamespaces() {
+	response, _ := doRequest(s.T(), http.DefaultClient, "POST", s.server.URL+"/configure_reflection",
+		`{"status": 201, "expiresAfter": "1s", "endpoints": [{"method": "GET", "url": "/a"}]}`, http.Header{namespaceHeader: {"test"}})
+	s.Require().Equal(http.StatusOK, response.StatusCode)
+
+	s.Equal(1, s.albedo.evictEndpoints(time.Now().Add(time.Second)))
+}
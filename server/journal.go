@@ -0,0 +1,427 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultJournalCapacity is the default number of requests retained by
+	// the request journal.
+	DefaultJournalCapacity = 1000
+	// DefaultJournalBodyLimit is the default number of body bytes retained
+	// per request by the request journal.
+	DefaultJournalBodyLimit = 64 * 1024
+
+	defaultWaitTimeout = 5 * time.Second
+	// maxWaitTimeout limits the time a wait request can hold up a graceful
+	// shutdown.
+	maxWaitTimeout = 5 * time.Minute
+)
+
+// journalEntry is a request recorded in the journal.
+type journalEntry struct {
+	ID        uint64        `json:"id"`
+	Timestamp time.Time     `json:"timestamp"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Path      string        `json:"path"`
+	Protocol  *protocolInfo `json:"protocol"`
+	// Headers are in wire order where possible, see requestHeaders.
+	Headers []headerField `json:"headers"`
+	// Body is set if the body is valid UTF-8, EncodedBody (base64) otherwise.
+	Body          string               `json:"body,omitempty"`
+	EncodedBody   string               `json:"encodedBody,omitempty"`
+	BodySize      int64                `json:"bodySize"`
+	BodyTruncated bool                 `json:"bodyTruncated,omitempty"`
+	RemoteAddr    string               `json:"remoteAddr"`
+	Endpoint      *dynamicEndpointSpec `json:"endpoint,omitempty"`
+}
+
+type journalResponse struct {
+	Entries []journalEntry `json:"entries"`
+}
+
+// journal is a bounded ring buffer of received requests. It is safe for
+// concurrent use. A journal with a capacity of 0 records nothing.
+type journal struct {
+	mutex     sync.Mutex
+	entries   []journalEntry
+	next      int
+	size      int
+	lastID    uint64
+	bodyLimit int
+	// changed is closed and replaced whenever an entry is added
+	changed chan struct{}
+}
+
+func newJournal(capacity int, bodyLimit int) *journal {
+	return &journal{
+		entries:   make([]journalEntry, max(capacity, 0)),
+		bodyLimit: max(bodyLimit, 0),
+		changed:   make(chan struct{}),
+	}
+}
+
+func (j *journal) enabled() bool {
+	return len(j.entries) > 0
+}
+
+func (j *journal) add(entry journalEntry) {
+	if !j.enabled() {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.lastID++
+	entry.ID = j.lastID
+	j.entries[j.next] = entry
+	j.next = (j.next + 1) % len(j.entries)
+	j.size = min(j.size+1, len(j.entries))
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// wait blocks until an entry matching the filter has been recorded or ctx
+// is done. Entries that were recorded before the call are considered as
+// well, the oldest match is returned.
+func (j *journal) wait(ctx context.Context, filter *journalFilter) (journalEntry, bool) {
+	var lastSeen uint64
+	for {
+		j.mutex.Lock()
+		for i := 0; i < j.size; i++ {
+			entry := &j.entries[(j.next-j.size+i+len(j.entries))%len(j.entries)]
+			if entry.ID > lastSeen && filter.matches(entry) {
+				j.mutex.Unlock()
+				return *entry, true
+			}
+		}
+		lastSeen = j.lastID
+		changed := j.changed
+		j.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return journalEntry{}, false
+		}
+	}
+}
+
+// query returns the entries matching the filter, oldest first.
+func (j *journal) query(filter *journalFilter) []journalEntry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	result := []journalEntry{}
+	for i := 0; i < j.size; i++ {
+		entry := &j.entries[(j.next-j.size+i+len(j.entries))%len(j.entries)]
+		if filter.matches(entry) {
+			result = append(result, *entry)
+		}
+	}
+	if filter.limit > 0 && len(result) > filter.limit {
+		result = result[len(result)-filter.limit:]
+	}
+	return result
+}
+
+func (j *journal) clear() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	clear(j.entries)
+	j.next = 0
+	j.size = 0
+}
+
+// journalFilter selects journal entries. Zero values match everything.
+type journalFilter struct {
+	method     string
+	pathPrefix string
+	headers    []headerFilter
+	since      time.Time
+	until      time.Time
+	limit      int
+}
+
+// headerFilter matches entries that have a header with the given name
+// (case-insensitive) and, if hasValue is set, the given value.
+type headerFilter struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// parseJournalFilter parses a filter from the query parameters "method",
+// "path" (prefix), "header" (repeatable, "name" or "name:value"), "since"
+// and "until" (RFC 3339) and "limit" (most recent entries).
+func parseJournalFilter(query url.Values) (*journalFilter, error) {
+	filter := &journalFilter{
+		method:     query.Get("method"),
+		pathPrefix: query.Get("path"),
+	}
+	for _, header := range query["header"] {
+		name, value, hasValue := strings.Cut(header, ":")
+		if name == "" {
+			return nil, fieldErrorf("header", "invalid header filter '%s'", header)
+		}
+		filter.headers = append(filter.headers, headerFilter{
+			name:     strings.TrimSpace(name),
+			value:    strings.TrimSpace(value),
+			hasValue: hasValue,
+		})
+	}
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return nil, fieldErrorf("since", "invalid timestamp '%s' for 'since'", since)
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.until, err = time.Parse(time.RFC3339Nano, until); err != nil {
+			return nil, fieldErrorf("until", "invalid timestamp '%s' for 'until'", until)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.limit, err = strconv.Atoi(limit); err != nil || filter.limit < 0 {
+			return nil, fieldErrorf("limit", "invalid limit '%s'", limit)
+		}
+	}
+	return filter, nil
+}
+
+func (f *journalFilter) matches(entry *journalEntry) bool {
+	if f.method != "" && !strings.EqualFold(f.method, entry.Method) {
+		return false
+	}
+	if !strings.HasPrefix(entry.Path, f.pathPrefix) {
+		return false
+	}
+	if !f.since.IsZero() && entry.Timestamp.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && entry.Timestamp.After(f.until) {
+		return false
+	}
+	for _, header := range f.headers {
+		if !header.matches(entry.Headers) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *headerFilter) matches(headers []headerField) bool {
+	for _, field := range headers {
+		if strings.EqualFold(field.Name, f.name) && (!f.hasValue || field.Value == f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// readBodyPrefix reads the body completely but only retains up to limit
+// bytes. It returns the retained bytes and the total size of the body.
+func readBodyPrefix(body io.Reader, limit int) ([]byte, int64, error) {
+	prefix, err := io.ReadAll(io.LimitReader(body, int64(limit)))
+	if err != nil {
+		return prefix, int64(len(prefix)), err
+	}
+	rest, err := io.Copy(io.Discard, body)
+	return prefix, int64(len(prefix)) + rest, err
+}
+
+// recordRequest adds the request to the journal. body holds the (possibly
+// partial) body of the request and bodySize the size of the complete body.
+func (a *Albedo) recordRequest(r *http.Request, body []byte, bodySize int64, endpoint *dynamicEndpointSpec) {
+	journal := a.namespace(r).journal
+	if !journal.enabled() {
+		return
+	}
+
+	entry := journalEntry{
+		Timestamp:  time.Now(),
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Path:       r.URL.Path,
+		Protocol:   getProtocolInfo(r),
+		Headers:    getRequestHeaders(r),
+		BodySize:   bodySize,
+		RemoteAddr: r.RemoteAddr,
+		Endpoint:   endpoint,
+	}
+	if len(body) > journal.bodyLimit {
+		body = body[:journal.bodyLimit]
+	}
+	entry.BodyTruncated = int64(len(body)) < bodySize
+	if utf8.Valid(body) {
+		entry.Body = string(body)
+	} else {
+		entry.EncodedBody = base64.StdEncoding.EncodeToString(body)
+	}
+	journal.add(entry)
+}
+
+func (a *Albedo) handleJournal(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received journal request")
+
+	filter, err := parseJournalFilter(r.URL.Query())
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, err)
+		return
+	}
+
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	response := &journalResponse{Entries: ns.journal.query(filter)}
+	var body []byte
+	if r.URL.Query().Get("pretty") == "true" {
+		body, err = json.MarshalIndent(response, "", "  ")
+	} else {
+		body, err = json.Marshal(response)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, errorCodeInternal, fmt.Errorf("Failed to marshal journal: %w", err))
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		a.log().Warn("Failed to write response body", "error", err.Error())
+	}
+}
+
+// handleWaitForRequest blocks until a request matching the filter has been
+// recorded (200 with the entry) or the timeout has elapsed (408). With
+// "expect=none", the semantics are inverted: the handler responds with 204
+// if no matching request was recorded within the timeout and with 409 and the
+// offending entry otherwise.
+func (a *Albedo) handleWaitForRequest(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received journal wait request")
+
+	query := r.URL.Query()
+	filter, err := parseJournalFilter(query)
+	var timeout time.Duration
+	if err == nil {
+		timeout, err = parseWaitTimeout(query.Get("timeout"))
+	}
+	expectNone := false
+	if err == nil {
+		switch expect := query.Get("expect"); expect {
+		case "", "any":
+		case "none":
+			expectNone = true
+		default:
+			err = fieldErrorf("expect", "invalid expectation '%s'", expect)
+		}
+	}
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, err)
+		return
+	}
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	journal := ns.journal
+	if !journal.enabled() {
+		a.writeError(w, http.StatusBadRequest, errorCodeUnsupported, errors.New("the request journal is disabled"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// don't hold up a graceful shutdown
+	shutdown := shuttingDown(r)
+	go func() {
+		select {
+		case <-shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	entry, found := journal.wait(ctx, filter)
+	select {
+	case <-shutdown:
+		if !found {
+			a.writeError(w, http.StatusServiceUnavailable, errorCodeShuttingDown, errors.New("The server is shutting down"))
+			return
+		}
+	default:
+	}
+	switch {
+	case found && expectNone:
+		a.log().Info("Received unexpected request while waiting", "id", entry.ID)
+		a.writeJournalEntry(w, r, http.StatusConflict, &entry)
+	case found:
+		a.writeJournalEntry(w, r, http.StatusOK, &entry)
+	case expectNone:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		a.log().Info("Timed out waiting for request", "timeout", timeout)
+		w.WriteHeader(http.StatusRequestTimeout)
+	}
+}
+
+func (a *Albedo) writeJournalEntry(w http.ResponseWriter, r *http.Request, status int, entry *journalEntry) {
+	var body []byte
+	var err error
+	if r.URL.Query().Get("pretty") == "true" {
+		body, err = json.MarshalIndent(entry, "", "  ")
+	} else {
+		body, err = json.Marshal(entry)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, errorCodeInternal, fmt.Errorf("Failed to marshal journal entry: %w", err))
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	if err != nil {
+		a.log().Warn("Failed to write response body", "error", err.Error())
+	}
+}
+
+// parseWaitTimeout parses a Go duration (e.g. "5s") or a plain number of
+// milliseconds, up to maxWaitTimeout. Defaults to defaultWaitTimeout.
+func parseWaitTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultWaitTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if milliseconds, atoiErr := strconv.ParseInt(value, 10, 64); atoiErr == nil {
+		// clamped to avoid overflows, the maximum is checked below
+		timeout, err = time.Duration(min(milliseconds, maxWaitTimeout.Milliseconds()+1))*time.Millisecond, nil
+	}
+	if err != nil || timeout < 0 {
+		return 0, fieldErrorf("timeout", "invalid timeout '%s'", value)
+	}
+	if timeout > maxWaitTimeout {
+		return 0, fieldErrorf("timeout", "timeout '%s' exceeds the maximum of %s", value, maxWaitTimeout)
+	}
+	return timeout, nil
+}
+
+func (a *Albedo) handleClearJournal(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received journal clear request. Discarding all recorded requests now")
+	if ns, ok := a.controlNamespace(w, r); ok {
+		ns.journal.clear()
+	}
+}
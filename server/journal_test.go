@@ -0,0 +1,252 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type journalTestSuite struct {
+	serverSuite
+}
+
+func TestJournalTestSuite(t *testing.T) {
+	suite.Run(t, new(journalTestSuite))
+}
+
+func (s *journalTestSuite) SetupTest() {
+	s.serveWire(WithJournalCapacity(3), WithJournalBodyLimit(8))
+}
+
+func (s *journalTestSuite) queryJournal(query string) []journalEntry {
+	response, body := s.do("GET", "/journal?"+query, "")
+	s.Require().Equal(http.StatusOK, response.StatusCode)
+	s.Equal("application/json", response.Header.Get("Content-Type"))
+	journal := &journalResponse{}
+	s.Require().NoError(json.Unmarshal([]byte(body), journal))
+	return journal.Entries
+}
+
+// get sends a request from a goroutine other than the test's, ignoring any
+// errors.
+func (s *journalTestSuite) get(path string, header http.Header) {
+	request, err := http.NewRequest("GET", s.server.URL+path, nil)
+	if err != nil {
+		return
+	}
+	maps.Copy(request.Header, header)
+	if response, err := http.DefaultClient.Do(request); err == nil {
+		_ = response.Body.Close()
+	}
+}
+
+func (s *journalTestSuite) TestRecordsRequests() {
+	response, _ := doRequest(s.T(), http.DefaultClient, "POST", s.server.URL+"/foo/bar?a=b", "short", http.Header{"X-Test": {"value"}})
+	s.Equal(http.StatusOK, response.StatusCode)
+
+	entries := s.queryJournal("")
+	s.Require().Len(entries, 1)
+	entry := entries[0]
+	s.Equal(uint64(1), entry.ID)
+	s.Equal("POST", entry.Method)
+	s.Equal("/foo/bar?a=b", entry.URI)
+	s.Equal("/foo/bar", entry.Path)
+	s.Equal("HTTP/1.1", entry.Protocol.Proto)
+	s.Equal("short", entry.Body)
+	s.Equal(int64(5), entry.BodySize)
+	s.False(entry.BodyTruncated)
+	s.NotEmpty(entry.RemoteAddr)
+	s.WithinDuration(time.Now(), entry.Timestamp, 5*time.Second)
+	s.Nil(entry.Endpoint)
+	s.Contains(entry.Headers, headerField{Name: "X-Test", Value: "value"})
+}
+
+func (s *journalTestSuite) TestHeadersInWireOrder() {
+	conn := s.dial()
+	_, err := conn.Write([]byte("GET /ordered HTTP/1.1\r\n" +
+		"Host: albedo\r\n" +
+		"x-second: 2\r\n" +
+		"A-First: 1\r\n" +
+		"x-second: 3\r\n" +
+		"Connection: close\r\n\r\n"))
+	s.Require().NoError(err)
+	_, err = io.ReadAll(conn)
+	s.Require().NoError(err)
+
+	entries := s.queryJournal("path=/ordered")
+	s.Require().Len(entries, 1)
+	s.Equal([]headerField{
+		{Name: "Host", Value: "albedo"},
+		{Name: "x-second", Value: "2"},
+		{Name: "A-First", Value: "1"},
+		{Name: "x-second", Value: "3"},
+		{Name: "Connection", Value: "close"},
+	}, entries[0].Headers)
+}
+
+func (s *journalTestSuite) TestHeadersInWireOrder_RequestLineInBody() {
+	conn := s.dial()
+	smuggled := "GET /x HTTP/1.1\r\nX-Smuggled: yes\r\n\r\n"
+	_, err := conn.Write([]byte(fmt.Sprintf("POST /a HTTP/1.1\r\nHost: albedo\r\nContent-Length: %d\r\n\r\n%s", len(smuggled), smuggled) +
+		"GET /x HTTP/1.1\r\n" +
+		"Host: albedo\r\n" +
+		"X-Real: yes\r\n" +
+		"Connection: close\r\n\r\n"))
+	s.Require().NoError(err)
+	_, err = io.ReadAll(conn)
+	s.Require().NoError(err)
+
+	entries := s.queryJournal("path=/x")
+	s.Require().Len(entries, 1)
+	s.Equal([]headerField{
+		{Name: "Host", Value: "albedo"},
+		{Name: "X-Real", Value: "yes"},
+		{Name: "Connection", Value: "close"},
+	}, entries[0].Headers)
+}
+
+func (s *journalTestSuite) TestBodyLimitAndEncoding() {
+	s.do("POST", "/long", "0123456789")
+	s.do("POST", "/binary", string([]byte{0xff, 0xfe}))
+
+	entries := s.queryJournal("")
+	s.Require().Len(entries, 2)
+	s.Equal("01234567", entries[0].Body)
+	s.Equal(int64(10), entries[0].BodySize)
+	s.True(entries[0].BodyTruncated)
+	s.Empty(entries[1].Body)
+	s.Equal(base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe}), entries[1].EncodedBody)
+}
+
+func (s *journalTestSuite) TestCapacity() {
+	for i := range 5 {
+		s.do("GET", fmt.Sprintf("/request/%d", i), "")
+	}
+
+	entries := s.queryJournal("")
+	s.Require().Len(entries, 3)
+	s.Equal("/request/2", entries[0].Path)
+	s.Equal(uint64(3), entries[0].ID)
+	s.Equal("/request/4", entries[2].Path)
+}
+
+func (s *journalTestSuite) TestMatchedEndpoint() {
+	spec := &configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 201},
+		Endpoints:      []dynamicEndpointSpec{{Method: "GET", Url: "/configured"}},
+	}
+	s.doJSON("POST", "/configure_reflection", spec)
+	response, _ := s.do("GET", "/configured", "")
+	s.Equal(201, response.StatusCode)
+
+	entries := s.queryJournal("")
+	s.Require().Len(entries, 1)
+	s.Equal(&dynamicEndpointSpec{Method: "GET", Url: "/configured"}, entries[0].Endpoint)
+}
+
+func (s *journalTestSuite) TestFilters() {
+	doRequest(s.T(), http.DefaultClient, "PUT", s.server.URL+"/api/users", "", http.Header{"X-Marker": {"abc"}})
+	s.do("GET", "/api/items", "")
+	s.do("GET", "/other", "")
+
+	s.Len(s.queryJournal("method=put"), 1)
+	s.Len(s.queryJournal("path=/api/"), 2)
+	s.Len(s.queryJournal("header=x-marker"), 1)
+	s.Len(s.queryJournal("header=x-marker:abc"), 1)
+	s.Len(s.queryJournal("header=x-marker:def"), 0)
+	s.Len(s.queryJournal("path=/api/&method=GET"), 1)
+	entries := s.queryJournal("limit=1")
+	s.Require().Len(entries, 1)
+	s.Equal("/other", entries[0].Path)
+
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	s.Len(s.queryJournal("since="+future), 0)
+	s.Len(s.queryJournal("until="+future), 3)
+
+	response, _ := s.do("GET", "/journal?since=yesterday", "")
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
+
+func (s *journalTestSuite) TestControlRequestsAreNotRecorded() {
+	s.do("GET", "/capabilities", "")
+	s.Empty(s.queryJournal(""))
+}
+
+func (s *journalTestSuite) TestClear() {
+	s.do("GET", "/foo", "")
+	s.Len(s.queryJournal(""), 1)
+
+	response, _ := s.do("DELETE", "/journal", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Empty(s.queryJournal(""))
+
+	s.do("GET", "/foo", "")
+	entries := s.queryJournal("")
+	s.Require().Len(entries, 1)
+	s.Equal(uint64(2), entries[0].ID)
+}
+
+func (s *journalTestSuite) TestDisabled() {
+	s.serve(WithJournalCapacity(0))
+
+	s.do("GET", "/foo", "")
+	s.Empty(s.albedo.global.journal.query(&journalFilter{}))
+}
+
+func (s *journalTestSuite) TestWait() {
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.get("/other", nil)
+		s.get("/awaited", http.Header{"X-Marker": {"abc"}})
+	}()
+
+	start := time.Now()
+	response, body := s.do("GET", "/journal/wait?path=/awaited&header=X-Marker:abc&timeout=5s", "")
+	s.Less(time.Since(start), 5*time.Second)
+	s.Equal(http.StatusOK, response.StatusCode)
+	entry := &journalEntry{}
+	s.Require().NoError(json.Unmarshal([]byte(body), entry))
+	s.Equal("/awaited", entry.Path)
+}
+
+func (s *journalTestSuite) TestWait_AlreadyRecorded() {
+	s.do("GET", "/earlier", "")
+
+	response, _ := s.do("GET", "/journal/wait?path=/earlier&timeout=10", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (s *journalTestSuite) TestWait_Timeout() {
+	response, _ := s.do("GET", "/journal/wait?path=/never&timeout=50ms", "")
+	s.Equal(http.StatusRequestTimeout, response.StatusCode)
+}
+
+func (s *journalTestSuite) TestWait_ExpectNone() {
+	response, _ := s.do("GET", "/journal/wait?path=/blocked&expect=none&timeout=50", "")
+	s.Equal(http.StatusNoContent, response.StatusCode)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.get("/blocked", nil)
+	}()
+	response, body := s.do("GET", "/journal/wait?path=/blocked&expect=none&timeout=5s", "")
+	s.Equal(http.StatusConflict, response.StatusCode)
+	entry := &journalEntry{}
+	s.Require().NoError(json.Unmarshal([]byte(body), entry))
+	s.Equal("/blocked", entry.Path)
+}
+
+func (s *journalTestSuite) TestWait_InvalidParameters() {
+	for _, query := range []string{"timeout=soon", "timeout=-1s", "timeout=6m", "timeout=300001", "timeout=9223372036854775807", "expect=maybe"} {
+		response, _ := s.do("GET", "/journal/wait?"+query, "")
+		s.Equal(http.StatusBadRequest, response.StatusCode, query)
+	}
+}
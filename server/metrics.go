@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition
+// format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// statusNone is the status label of requests that didn't receive a regular
+// response, e.g., because the connection was hijacked or aborted.
+const statusNone = "none"
+
+// bodySizeBuckets are the upper bounds of the body size histograms, in bytes.
+var bodySizeBuckets = []float64{0, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+type metricsContextKey struct{}
+
+// requestKey identifies a series of albedo_http_requests_total.
+type requestKey struct {
+	method   string
+	status   string
+	endpoint string
+}
+
+// histogram is a Prometheus histogram with fixed buckets.
+type histogram struct {
+	buckets []float64
+	// counts holds the number of observations per bucket, not cumulative;
+	// the last element counts the observations above all buckets
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	index, _ := slices.BinarySearch(h.buckets, value)
+	h.counts[index]++
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	cumulative := uint64(0)
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// metrics collects the metrics exposed by "/metrics".
+type metrics struct {
+	mutex             sync.Mutex
+	requests          map[requestKey]uint64
+	requestBodySizes  *histogram
+	responseBodySizes *histogram
+
+	reflections       atomic.Uint64
+	configurations    atomic.Uint64
+	resets            atomic.Uint64
+	activeConnections atomic.Int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:          map[requestKey]uint64{},
+		requestBodySizes:  newHistogram(bodySizeBuckets),
+		responseBodySizes: newHistogram(bodySizeBuckets),
+	}
+}
+
+func (m *metrics) observeRequest(key requestKey, requestBodySize int64, responseBodySize int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests[key]++
+	m.requestBodySizes.observe(float64(requestBodySize))
+	m.responseBodySizes.observe(float64(responseBodySize))
+}
+
+// write writes the metrics in the Prometheus text exposition format.
+// dynamicEndpoints is the number of currently configured dynamic endpoints.
+func (m *metrics) write(w io.Writer, dynamicEndpoints int) {
+	m.mutex.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a requestKey, b requestKey) int {
+		return cmp.Or(cmp.Compare(a.endpoint, b.endpoint), cmp.Compare(a.method, b.method), cmp.Compare(a.status, b.status))
+	})
+	fmt.Fprintln(w, "# HELP albedo_http_requests_total Number of HTTP requests received, by method, response status and endpoint.")
+	fmt.Fprintln(w, "# TYPE albedo_http_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "albedo_http_requests_total{method=\"%s\",status=\"%s\",endpoint=\"%s\"} %d\n",
+			escapeLabelValue(key.method), escapeLabelValue(key.status), escapeLabelValue(key.endpoint), m.requests[key])
+	}
+	fmt.Fprintln(w, "# HELP albedo_http_request_body_size_bytes Size of the request bodies read.")
+	fmt.Fprintln(w, "# TYPE albedo_http_request_body_size_bytes histogram")
+	m.requestBodySizes.write(w, "albedo_http_request_body_size_bytes")
+	fmt.Fprintln(w, "# HELP albedo_http_response_body_size_bytes Size of the response bodies written.")
+	fmt.Fprintln(w, "# TYPE albedo_http_response_body_size_bytes histogram")
+	m.responseBodySizes.write(w, "albedo_http_response_body_size_bytes")
+	m.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP albedo_reflections_total Number of responses rendered from a reflection specification.")
+	fmt.Fprintln(w, "# TYPE albedo_reflections_total counter")
+	fmt.Fprintf(w, "albedo_reflections_total %d\n", m.reflections.Load())
+	fmt.Fprintln(w, "# HELP albedo_configurations_total Number of endpoint configurations applied.")
+	fmt.Fprintln(w, "# TYPE albedo_configurations_total counter")
+	fmt.Fprintf(w, "albedo_configurations_total %d\n", m.configurations.Load())
+	fmt.Fprintln(w, "# HELP albedo_resets_total Number of resets.")
+	fmt.Fprintln(w, "# TYPE albedo_resets_total counter")
+	fmt.Fprintf(w, "albedo_resets_total %d\n", m.resets.Load())
+	fmt.Fprintln(w, "# HELP albedo_active_connections Number of open client connections.")
+	fmt.Fprintln(w, "# TYPE albedo_active_connections gauge")
+	fmt.Fprintf(w, "albedo_active_connections %d\n", m.activeConnections.Load())
+	fmt.Fprintln(w, "# HELP albedo_dynamic_endpoints Number of configured dynamic endpoints, in all namespaces.")
+	fmt.Fprintln(w, "# TYPE albedo_dynamic_endpoints gauge")
+	fmt.Fprintf(w, "albedo_dynamic_endpoints %d\n", dynamicEndpoints)
+}
+
+// The counters can be incremented on a nil *metrics, so that handlers don't
+// need to check whether metrics are enabled.
+
+func (m *metrics) countReflection() {
+	if m != nil {
+		m.reflections.Add(1)
+	}
+}
+
+func (m *metrics) countConfiguration() {
+	if m != nil {
+		m.configurations.Add(1)
+	}
+}
+
+func (m *metrics) countReset() {
+	if m != nil {
+		m.resets.Add(1)
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+// requestMetrics is stored in the request context so that handlers can
+// report the endpoint that served the request.
+type requestMetrics struct {
+	endpoint string
+}
+
+// setMetricsEndpoint sets the endpoint label of the request.
+func setMetricsEndpoint(r *http.Request, endpoint string) {
+	if info, ok := r.Context().Value(metricsContextKey{}).(*requestMetrics); ok {
+		info.endpoint = endpoint
+	}
+}
+
+// withMetrics counts the requests served by mux. The endpoint label is the
+// path of the built-in endpoint, the URL of the matched dynamic endpoint, or
+// "/*" for the default endpoint.
+func (a *Albedo) withMetrics(mux *http.ServeMux) http.Handler {
+	if a.metrics == nil {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestMetrics{endpoint: "/*"}
+		if _, pattern := mux.Handler(r); pattern != "/" {
+			// strip method and trailing slash, e.g. "POST /reflect/"
+			_, path, _ := strings.Cut(pattern, "/")
+			info.endpoint = "/" + strings.TrimSuffix(path, "/")
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		recorder := &metricsRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			status := statusNone
+			if completed && !recorder.hijacked {
+				status = strconv.Itoa(cmp.Or(recorder.status, http.StatusOK))
+			}
+			key := requestKey{method: r.Method, status: status, endpoint: info.endpoint}
+			a.metrics.observeRequest(key, body.size, recorder.size)
+		}()
+		next := r.WithContext(context.WithValue(r.Context(), metricsContextKey{}, info))
+		mux.ServeHTTP(recorder, next)
+		completed = true
+	})
+}
+
+func (a *Albedo) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	a.evictEndpoints(time.Now())
+	dynamicEndpoints := 0
+	for _, ns := range a.allNamespaces() {
+		dynamicEndpoints += ns.endpoints.len()
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	a.metrics.write(w, dynamicEndpoints)
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	size int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// metricsRecorder records the status and body size of a response.
+type metricsRecorder struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+}
+
+func (w *metricsRecorder) WriteHeader(status int) {
+	// informational responses precede the final response
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsRecorder) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *metricsRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, buffer, err
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer.
+func (w *metricsRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// connectionCountingListener tracks the number of open connections it
+// accepted.
+type connectionCountingListener struct {
+	net.Listener
+	active *atomic.Int64
+}
+
+func (l *connectionCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.active.Add(1)
+	return &countedConn{Conn: conn, active: l.active}, nil
+}
+
+type countedConn struct {
+	net.Conn
+	active *atomic.Int64
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// NetConn returns the underlying connection, like tls.Conn.
+func (c *countedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// countConnections wraps listener so that its connections are counted, if
+// metrics are enabled.
+func (a *Albedo) countConnections(listener net.Listener) net.Listener {
+	if a.metrics == nil {
+		return listener
+	}
+	return &connectionCountingListener{Listener: listener, active: &a.metrics.activeConnections}
+}
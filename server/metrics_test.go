@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type metricsTestSuite struct {
+	serverSuite
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(metricsTestSuite))
+}
+
+func (s *metricsTestSuite) SetupTest() {
+	s.albedo = New(WithMetrics())
+	s.server = httptest.NewUnstartedServer(s.albedo.Handler())
+	s.server.Listener = s.albedo.countConnections(s.server.Listener)
+	s.server.Start()
+	s.T().Cleanup(s.server.Close)
+}
+
+func (s *metricsTestSuite) metrics() string {
+	response, body := s.do("GET", "/metrics", "")
+	s.Equal(metricsContentType, response.Header.Get("Content-Type"))
+	return body
+}
+
+func (s *metricsTestSuite) TestRequests() {
+	s.do("POST", "/configure_reflection", `{"status": 201, "endpoints": [{"method": "GET", "url": "/users/{id}", "match": "pattern"}]}`)
+	s.do("GET", "/users/1", "")
+	s.do("GET", "/users/2", "")
+	s.do("GET", "/unknown", "")
+	s.do("POST", "/reflect/", `{"status": 503, "body": "down"}`)
+	s.do("POST", "/reflect", `{"status": 1000}`)
+
+	metrics := s.metrics()
+	s.Contains(metrics, "# TYPE albedo_http_requests_total counter\n")
+	s.Contains(metrics, `albedo_http_requests_total{method="POST",status="200",endpoint="/configure_reflection"} 1`+"\n")
+	s.Contains(metrics, `albedo_http_requests_total{method="GET",status="201",endpoint="/users/{id}"} 2`+"\n")
+	s.Contains(metrics, `albedo_http_requests_total{method="GET",status="200",endpoint="/*"} 1`+"\n")
+	s.Contains(metrics, `albedo_http_requests_total{method="POST",status="503",endpoint="/reflect"} 1`+"\n")
+	s.Contains(metrics, `albedo_http_requests_total{method="POST",status="400",endpoint="/reflect"} 1`+"\n")
+	s.Contains(metrics, "albedo_reflections_total 3\n")
+	s.Contains(metrics, "albedo_configurations_total 1\n")
+	s.Contains(metrics, "albedo_dynamic_endpoints 1\n")
+	s.Contains(metrics, "albedo_active_connections 1\n")
+}
+
+func (s *metricsTestSuite) TestBodySizes() {
+	s.do("POST", "/anything", strings.Repeat("a", 100))
+	s.do("POST", "/reflect", `{"body": "`+strings.Repeat("b", 2000)+`"}`)
+
+	metrics := s.metrics()
+	s.Contains(metrics, "# TYPE albedo_http_request_body_size_bytes histogram\n")
+	s.Contains(metrics, `albedo_http_request_body_size_bytes_bucket{le="64"} 0`+"\n")
+	s.Contains(metrics, `albedo_http_request_body_size_bytes_bucket{le="256"} 1`+"\n")
+	s.Contains(metrics, `albedo_http_request_body_size_bytes_bucket{le="4096"} 2`+"\n")
+	s.Contains(metrics, `albedo_http_request_body_size_bytes_bucket{le="+Inf"} 2`+"\n")
+	s.Contains(metrics, "albedo_http_request_body_size_bytes_sum 2112\n")
+	s.Contains(metrics, "albedo_http_request_body_size_bytes_count 2\n")
+	s.Contains(metrics, `albedo_http_request_body_size_bytes_bucket{le="1048576"} 2`+"\n")
+	s.Contains(metrics, `albedo_http_response_body_size_bytes_bucket{le="0"} 1`+"\n")
+	s.Contains(metrics, `albedo_http_response_body_size_bytes_bucket{le="1024"} 1`+"\n")
+	s.Contains(metrics, `albedo_http_response_body_size_bytes_bucket{le="4096"} 2`+"\n")
+	s.Contains(metrics, "albedo_http_response_body_size_bytes_sum 2000\n")
+}
+
+func (s *metricsTestSuite) TestResets() {
+	s.do("PUT", "/reset", "")
+	s.do("PUT", "/_session/a/reset", "")
+	s.Contains(s.metrics(), "albedo_resets_total 2\n")
+}
+
+func (s *metricsTestSuite) TestHijacked() {
+	s.do("POST", "/configure_reflection", `{"fault": {"mode": "close"}, "endpoints": [{"method": "POST", "url": "/broken"}]}`)
+	// POST requests aren't retried by the client
+	_, err := http.Post(s.server.URL+"/broken", "text/plain", nil)
+	s.Require().Error(err)
+	s.Contains(s.metrics(), `albedo_http_requests_total{method="POST",status="none",endpoint="/broken"} 1`+"\n")
+}
+
+func (s *metricsTestSuite) TestEscapeLabelValue() {
+	s.Equal(`a\\b\"c\nd`, escapeLabelValue("a\\b\"c\nd"))
+}
+
+func (s *metricsTestSuite) TestDisabled() {
+	s.serve()
+	response, body := s.do("GET", "/metrics", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	// handled by the default endpoint
+	s.Empty(body)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// namespaceHeader selects the namespace of a request.
+	namespaceHeader = "X-Albedo-Session"
+	// namespacePathPrefix selects the namespace of a request through the
+	// path, e.g., "/_session/test-1/reset". The prefix is removed before the
+	// request is routed.
+	namespacePathPrefix = "/_session/"
+	// maxNamespaces limits the number of namespaces, as every namespace
+	// holds its own journal.
+	maxNamespaces = 256
+)
+
+var namespaceNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type namespaceContextKey struct{}
+
+// namespace isolates dynamic endpoints and request journal of parallel test
+// runs. Requests without a namespace use the global namespace; requests in a
+// namespace fall back to the endpoints of the global namespace if none of
+// the namespace's endpoints match.
+type namespace struct {
+	// name is empty for the global namespace
+	name      string
+	endpoints *endpointRegistry
+	journal   *journal
+}
+
+// withNamespace determines the namespace of the request from the path
+// prefix or the namespace header and stores its name in the request context.
+func (a *Albedo) withNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(namespaceHeader)
+		if rest, ok := strings.CutPrefix(r.URL.Path, namespacePathPrefix); ok {
+			name, _, _ = strings.Cut(rest, "/")
+			r = stripPathPrefix(r, namespacePathPrefix+name)
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !namespaceNameRegex.MatchString(name) {
+			a.writeError(w, http.StatusBadRequest, errorCodeInvalidNamespace, fmt.Errorf("Invalid namespace '%s'", name))
+			return
+		}
+		next.ServeHTTP(w, withNamespaceName(r, name))
+	})
+}
+
+// withDataNamespace is the variant of withNamespace for handlers that only
+// serve the traffic under test, where every path is ordinary traffic. The
+// path prefix only selects a namespace that exists, other paths below
+// namespacePathPrefix are left untouched, and invalid namespace names are
+// ignored, i.e., such requests use the global namespace.
+func (a *Albedo) withDataNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(namespaceHeader)
+		if rest, ok := strings.CutPrefix(r.URL.Path, namespacePathPrefix); ok {
+			if prefixName, _, _ := strings.Cut(rest, "/"); a.hasNamespace(prefixName) {
+				name = prefixName
+				r = stripPathPrefix(r, namespacePathPrefix+name)
+			}
+		}
+		if name == "" || !namespaceNameRegex.MatchString(name) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, withNamespaceName(r, name))
+	})
+}
+
+// withNamespaceName returns a shallow copy of the request with the name of
+// its namespace stored in the context.
+func withNamespaceName(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), namespaceContextKey{}, name))
+}
+
+// stripPathPrefix returns a shallow copy of the request with the prefix
+// removed from its path and request URI.
+func stripPathPrefix(r *http.Request, prefix string) *http.Request {
+	strip := func(value string) string {
+		value = strings.TrimPrefix(value, prefix)
+		if !strings.HasPrefix(value, "/") {
+			value = "/" + value
+		}
+		return value
+	}
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.URL = new(url.URL)
+	*stripped.URL = *r.URL
+	stripped.URL.Path = strip(r.URL.Path)
+	if r.URL.RawPath != "" {
+		stripped.URL.RawPath = strip(r.URL.RawPath)
+	}
+	stripped.RequestURI = strip(r.RequestURI)
+	return stripped
+}
+
+// errTooManyNamespaces is returned when a namespace can't be created
+// because maxNamespaces namespaces exist already.
+var errTooManyNamespaces = fmt.Errorf("Too many namespaces, at most %d namespaces can exist at the same time", maxNamespaces)
+
+// namespaceName returns the name of the request's namespace, or an empty
+// string for the global namespace.
+func namespaceName(r *http.Request) string {
+	name, _ := r.Context().Value(namespaceContextKey{}).(string)
+	return name
+}
+
+// namespace returns the namespace of the request. Namespaces are only created
+// by requests to the built-in endpoints, so that ordinary traffic can't
+// allocate namespaces; requests in an unknown namespace use the global
+// namespace.
+func (a *Albedo) namespace(r *http.Request) *namespace {
+	name := namespaceName(r)
+	if name == "" {
+		return a.global
+	}
+	a.namespacesMutex.Lock()
+	defer a.namespacesMutex.Unlock()
+	if ns, ok := a.namespaces[name]; ok {
+		return ns
+	}
+	return a.global
+}
+
+// createNamespace returns the namespace of the request, creating it if
+// necessary.
+func (a *Albedo) createNamespace(r *http.Request) (*namespace, error) {
+	name := namespaceName(r)
+	if name == "" {
+		return a.global, nil
+	}
+	a.namespacesMutex.Lock()
+	defer a.namespacesMutex.Unlock()
+	ns, ok := a.namespaces[name]
+	if !ok {
+		if len(a.namespaces) >= maxNamespaces {
+			return nil, errTooManyNamespaces
+		}
+		ns = &namespace{
+			name:      name,
+			endpoints: newEndpointRegistry(),
+			journal:   newJournal(a.journalCapacity, a.journalBodyLimit),
+		}
+		a.namespaces[name] = ns
+		a.log().Info(fmt.Sprintf("Created namespace '%s'", name))
+	}
+	return ns, nil
+}
+
+// controlNamespace returns the namespace of a request to a built-in
+// endpoint, creating it if necessary, with unavailable endpoints evicted. If
+// the namespace can't be created, an error is written to w.
+func (a *Albedo) controlNamespace(w http.ResponseWriter, r *http.Request) (*namespace, bool) {
+	ns, err := a.createNamespace(r)
+	if err != nil {
+		a.writeError(w, http.StatusServiceUnavailable, errorCodeTooManyNamespaces, err)
+		return nil, false
+	}
+	a.evictNamespaceEndpoints(ns, time.Now())
+	return ns, true
+}
+
+// hasNamespace reports whether the named namespace exists.
+func (a *Albedo) hasNamespace(name string) bool {
+	a.namespacesMutex.Lock()
+	defer a.namespacesMutex.Unlock()
+	_, ok := a.namespaces[name]
+	return ok
+}
+
+// allNamespaces returns the global namespace followed by all other
+// namespaces.
+func (a *Albedo) allNamespaces() []*namespace {
+	a.namespacesMutex.Lock()
+	defer a.namespacesMutex.Unlock()
+	namespaces := []*namespace{a.global}
+	for _, ns := range a.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+// removeNamespace discards the named namespace with its endpoints and
+// journal.
+func (a *Albedo) removeNamespace(name string) {
+	a.namespacesMutex.Lock()
+	defer a.namespacesMutex.Unlock()
+	delete(a.namespaces, name)
+}
+
+// lookup returns the endpoint of the namespace matching the request, or the
+// matching endpoint of the global namespace.
+func (a *Albedo) lookup(ns *namespace, r *http.Request, body []byte) (*dynamicEndpoint, bool) {
+	if endpoint, ok := ns.endpoints.lookup(r, body); ok || ns == a.global {
+		return endpoint, ok
+	}
+	return a.global.endpoints.lookup(r, body)
+}
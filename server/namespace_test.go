@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type namespaceTestSuite struct {
+	serverSuite
+}
+
+func TestNamespaceTestSuite(t *testing.T) {
+	suite.Run(t, new(namespaceTestSuite))
+}
+
+func (s *namespaceTestSuite) SetupTest() {
+	s.serve()
+}
+
+// doInSession sends a request to the test server with the session in the
+// namespace header, unless it is empty.
+func (s *namespaceTestSuite) doInSession(method string, path string, session string, body string) (*http.Response, string) {
+	header := http.Header{}
+	if session != "" {
+		header.Set(namespaceHeader, session)
+	}
+	return doRequest(s.T(), http.DefaultClient, method, s.server.URL+path, body, header)
+}
+
+func (s *namespaceTestSuite) configure(path string, session string, status int, url string) {
+	spec := fmt.Sprintf(`{"status": %d, "endpoints": [{"method": "GET", "url": %q}]}`, status, url)
+	response, body := s.doInSession("POST", path, session, spec)
+	s.Require().Equal(http.StatusOK, response.StatusCode, body)
+}
+
+func (s *namespaceTestSuite) TestIsolation() {
+	s.configure("/configure_reflection", "a", 201, "/login")
+	s.configure("/configure_reflection", "b", 202, "/login")
+
+	response, _ := s.doInSession("GET", "/login", "a", "")
+	s.Equal(201, response.StatusCode)
+	response, _ = s.doInSession("GET", "/login", "b", "")
+	s.Equal(202, response.StatusCode)
+	response, _ = s.doInSession("GET", "/login", "", "")
+	s.Equal(200, response.StatusCode)
+	response, _ = s.doInSession("GET", "/login", "unknown", "")
+	s.Equal(200, response.StatusCode)
+
+	// ordinary traffic doesn't create namespaces
+	s.albedo.namespacesMutex.Lock()
+	s.Len(s.albedo.namespaces, 2)
+	s.albedo.namespacesMutex.Unlock()
+
+	_, body := s.doInSession("GET", "/endpoints", "a", "")
+	spec := &endpointsSpec{}
+	s.Require().NoError(json.Unmarshal([]byte(body), spec))
+	s.Require().Len(spec.Endpoints, 1)
+	s.Equal(uint64(1), spec.Endpoints[0].Hits)
+	s.Empty(s.albedo.global.endpoints.list())
+}
+
+func (s *namespaceTestSuite) TestFallbackToGlobal() {
+	s.configure("/configure_reflection", "", 203, "/global")
+	s.configure("/configure_reflection", "", 203, "/shadowed")
+	s.configure("/configure_reflection", "a", 204, "/shadowed")
+
+	response, _ := s.doInSession("GET", "/global", "a", "")
+	s.Equal(203, response.StatusCode)
+	response, _ = s.doInSession("GET", "/global", "never-configured", "")
+	s.Equal(203, response.StatusCode)
+	response, _ = s.doInSession("GET", "/shadowed", "a", "")
+	s.Equal(204, response.StatusCode)
+	response, _ = s.doInSession("GET", "/shadowed", "", "")
+	s.Equal(203, response.StatusCode)
+}
+
+func (s *namespaceTestSuite) TestPathPrefix() {
+	s.configure("/_session/a/configure_reflection", "", 201, "/login?next=%2Fhome")
+
+	response, _ := s.doInSession("GET", "/_session/a/login?next=%2Fhome", "", "")
+	s.Equal(201, response.StatusCode)
+	response, _ = s.doInSession("GET", "/login?next=%2Fhome", "a", "")
+	s.Equal(201, response.StatusCode)
+	response, _ = s.doInSession("GET", "/login?next=%2Fhome", "", "")
+	s.Equal(200, response.StatusCode)
+
+	// the prefix takes precedence over the header
+	response, _ = s.doInSession("GET", "/_session/a/login?next=%2Fhome", "b", "")
+	s.Equal(201, response.StatusCode)
+
+	_, body := s.doInSession("GET", "/_session/a/echo", "", "")
+	echo := &echoResponse{}
+	s.Require().NoError(json.Unmarshal([]byte(body), echo))
+	s.Equal("/echo", echo.URI)
+	s.Equal("/echo", echo.Path)
+}
+
+func (s *namespaceTestSuite) TestJournal() {
+	journal := func(session string) []string {
+		_, body := s.doInSession("GET", "/journal", session, "")
+		response := &journalResponse{}
+		s.Require().NoError(json.Unmarshal([]byte(body), response))
+		uris := []string{}
+		for _, entry := range response.Entries {
+			uris = append(uris, entry.URI)
+		}
+		return uris
+	}
+	// the journal requests create the namespaces
+	s.Empty(journal("a"))
+	s.Empty(journal("b"))
+
+	s.doInSession("GET", "/in-a", "a", "")
+	s.doInSession("GET", "/_session/b/in-b", "", "")
+	s.doInSession("GET", "/global", "", "")
+	s.doInSession("GET", "/in-unknown", "unknown", "")
+
+	s.Equal([]string{"/in-a"}, journal("a"))
+	s.Equal([]string{"/in-b"}, journal("b"))
+	s.Equal([]string{"/global", "/in-unknown"}, journal(""))
+
+	response, _ := s.doInSession("DELETE", "/journal", "a", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Empty(journal("a"))
+	s.Equal([]string{"/in-b"}, journal("b"))
+}
+
+func (s *namespaceTestSuite) TestReset() {
+	s.configure("/configure_reflection", "", 201, "/login")
+	s.configure("/configure_reflection", "a", 202, "/login")
+
+	response, _ := s.doInSession("PUT", "/reset", "a", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	response, _ = s.doInSession("GET", "/login", "a", "")
+	s.Equal(201, response.StatusCode)
+	s.Len(s.albedo.global.endpoints.list(), 1)
+
+	s.albedo.namespacesMutex.Lock()
+	s.Empty(s.albedo.namespaces)
+	s.albedo.namespacesMutex.Unlock()
+
+	// resetting an unknown namespace doesn't touch the global namespace
+	response, _ = s.doInSession("PUT", "/reset", "unknown", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Len(s.albedo.global.endpoints.list(), 1)
+}
+
+func (s *namespaceTestSuite) TestInvalidNamespace() {
+	response, body := s.doInSession("GET", "/login", "a/b", "")
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "invalid_namespace", "message": "Invalid namespace 'a/b'"}`, body)
+	response, _ = s.doInSession("GET", "/_session/"+strings.Repeat("a", 65)+"/login", "", "")
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
+
+func (s *namespaceTestSuite) TestDataHandler() {
+	data := httptest.NewServer(s.albedo.DataHandler())
+	s.T().Cleanup(data.Close)
+	get := func(path string, session string) int {
+		header := http.Header{}
+		if session != "" {
+			header.Set(namespaceHeader, session)
+		}
+		response, _ := doRequest(s.T(), http.DefaultClient, "GET", data.URL+path, "", header)
+		return response.StatusCode
+	}
+	s.configure("/configure_reflection", "a", 201, "/login")
+	s.configure("/configure_reflection", "", 202, "/_session/unknown/login")
+
+	s.Equal(201, get("/_session/a/login", ""))
+	s.Equal(201, get("/login", "a"))
+	// paths below the prefix are ordinary traffic unless the namespace exists
+	s.Equal(202, get("/_session/unknown/login", ""))
+	s.Equal(http.StatusOK, get("/_session/"+strings.Repeat("a", 65)+"/login", ""))
+	// invalid namespaces are ignored
+	s.Equal(http.StatusOK, get("/login", "a/b"))
+
+	entries := s.albedo.global.journal.query(&journalFilter{})
+	s.Require().Len(entries, 3)
+	s.Equal("/_session/unknown/login", entries[0].URI)
+}
+
+func (s *namespaceTestSuite) TestMaxNamespaces() {
+	for i := range maxNamespaces {
+		s.configure("/configure_reflection", fmt.Sprintf("ns-%d", i), 201, "/login")
+	}
+	response, body := s.doInSession("POST", "/configure_reflection", "one-too-many", `{"status": 202, "endpoints": [{"method": "GET", "url": "/login"}]}`)
+	s.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	s.Equal(errorCodeTooManyNamespaces, response.Header.Get(errorHeader))
+	s.JSONEq(`{"code": "too_many_namespaces", "message": "Too many namespaces, at most 256 namespaces can exist at the same time"}`, body)
+	response, _ = s.doInSession("PUT", "/reset", "one-too-many", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+
+	s.albedo.namespacesMutex.Lock()
+	s.Len(s.albedo.namespaces, maxNamespaces)
+	s.albedo.namespacesMutex.Unlock()
+	// the global namespace isn't affected
+	s.Empty(s.albedo.global.endpoints.list())
+	response, _ = s.doInSession("GET", "/login", "one-too-many", "")
+	s.Equal(http.StatusOK, response.StatusCode)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// endpointsFileWatchInterval is the interval at which the endpoints file is
+// checked for changes.
+const endpointsFileWatchInterval = time.Second
+
+// endpointsFile is a file holding a list of configureReflectionSpec
+// documents, in YAML or JSON.
+type endpointsFile struct {
+	path string
+	// persist enables writing configuration changes back to the file
+	persist bool
+
+	mutex sync.Mutex
+	// hash is the hash of the content last loaded or written, used to
+	// detect changes
+	hash [sha256.Size]byte
+}
+
+// parseEndpointsFile parses a list of specifications. As YAML is a superset
+// of JSON, both formats are supported.
+func parseEndpointsFile(content []byte) ([]configureReflectionSpec, error) {
+	// the specification types only have JSON tags, convert YAML to JSON first
+	var document any
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		return []configureReflectionSpec{}, nil
+	}
+	converted, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(converted))
+	decoder.DisallowUnknownFields()
+	specs := []configureReflectionSpec{}
+	if err = decoder.Decode(&specs); err != nil {
+		return nil, err
+	}
+	for i := range specs {
+		if err := validateConfiguration(&specs[i]); err != nil {
+			return nil, fmt.Errorf("specification %d: %w", i, err)
+		}
+	}
+	return specs, nil
+}
+
+// formatEndpointsFile renders the specifications as YAML or JSON, depending
+// on the extension of the path.
+func formatEndpointsFile(path string, specs []configureReflectionSpec) ([]byte, error) {
+	content, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var document any
+		if err = json.Unmarshal(content, &document); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(document)
+	}
+	return append(content, '\n'), nil
+}
+
+// loadEndpointsFile replaces the configured endpoints with the endpoints of
+// the endpoints file. A missing file is not an error in persistence mode, as
+// the file will be created on the first change.
+func (a *Albedo) loadEndpointsFile() error {
+	file := a.endpointsFile
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	content, err := os.ReadFile(file.path)
+	if err != nil {
+		if file.persist && errors.Is(err, fs.ErrNotExist) {
+			a.log().Info("Endpoints file does not exist yet", "file", file.path)
+			return nil
+		}
+		return err
+	}
+	return a.applyEndpointsFile(content)
+}
+
+func (a *Albedo) applyEndpointsFile(content []byte) error {
+	file := a.endpointsFile
+	file.hash = sha256.Sum256(content)
+	specs, err := parseEndpointsFile(content)
+	if err != nil {
+		return fmt.Errorf("invalid endpoints file %s: %w", file.path, err)
+	}
+	if err = a.global.endpoints.replace(specs); err != nil {
+		return fmt.Errorf("invalid endpoints file %s: %w", file.path, err)
+	}
+	a.log().Info(fmt.Sprintf("Loaded %d endpoint configurations", len(specs)), "file", file.path)
+	return nil
+}
+
+// reloadEndpointsFile loads the endpoints file if it changed since it was
+// last loaded or written.
+func (a *Albedo) reloadEndpointsFile() {
+	file := a.endpointsFile
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	content, err := os.ReadFile(file.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			a.log().Warn("Failed to read endpoints file", "file", file.path, "error", err.Error())
+		}
+		return
+	}
+	if sha256.Sum256(content) == file.hash {
+		return
+	}
+	a.log().Info("Endpoints file changed, reloading", "file", file.path)
+	if err = a.applyEndpointsFile(content); err != nil {
+		// keep the current configuration
+		a.log().Warn("Failed to reload endpoints file", "error", err.Error())
+	}
+}
+
+// watchEndpointsFile reloads the endpoints file whenever it changes, until
+// ctx is done.
+func (a *Albedo) watchEndpointsFile(ctx context.Context) {
+	ticker := time.NewTicker(endpointsFileWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.reloadEndpointsFile()
+		}
+	}
+}
+
+// persistEndpoints writes the configured endpoints to the endpoints file, if
+// persistence is enabled and ns is the global namespace. The file is replaced
+// atomically.
+func (a *Albedo) persistEndpoints(ns *namespace) {
+	file := a.endpointsFile
+	if file == nil || !file.persist || ns != a.global {
+		return
+	}
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	content, err := formatEndpointsFile(file.path, a.global.endpoints.specs())
+	if err == nil {
+		err = writeFileAtomically(file.path, content)
+	}
+	if err != nil {
+		a.log().Error("Failed to persist endpoints", "file", file.path, "error", err.Error())
+		return
+	}
+	file.hash = sha256.Sum256(content)
+	a.log().Debug("Persisted endpoints", "file", file.path)
+}
+
+// writeFileAtomically writes the content to a temporary file next to path
+// and renames it to path, so that readers never observe a partial file.
+func writeFileAtomically(path string, content []byte) error {
+	temporary, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// no-op after a successful rename
+		_ = os.Remove(temporary.Name())
+	}()
+	if _, err = temporary.Write(content); err == nil {
+		err = temporary.Sync()
+	}
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(temporary.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
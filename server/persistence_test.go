@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type persistenceTestSuite struct {
+	suite.Suite
+	directory string
+}
+
+func TestPersistenceTestSuite(t *testing.T) {
+	suite.Run(t, new(persistenceTestSuite))
+}
+
+func (s *persistenceTestSuite) SetupTest() {
+	s.directory = s.T().TempDir()
+}
+
+func (s *persistenceTestSuite) writeFile(name string, content string) string {
+	path := filepath.Join(s.directory, name)
+	s.Require().NoError(os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func (s *persistenceTestSuite) lookup(albedo *Albedo, method string, url string) (*dynamicEndpoint, bool) {
+	request := httptest.NewRequest(method, url, nil)
+	return albedo.global.endpoints.lookup(request, nil)
+}
+
+func (s *persistenceTestSuite) TestParseEndpointsFile() {
+	specs, err := parseEndpointsFile([]byte(`
+- endpoints:
+    - method: GET
+      url: /login
+  status: 201
+  encodedBody: Ym9keQ==
+  headers:
+    X-Test: "1"
+- endpoints:
+    - {method: any, url: /api/, match: prefix}
+  responses:
+    - status: 503
+  afterLast: loop
+`))
+	s.Require().NoError(err)
+	s.Require().Len(specs, 2)
+	s.Equal(201, specs[0].Status)
+	s.Equal("Ym9keQ==", specs[0].EncodedBody)
+	s.Equal(map[string]string{"X-Test": "1"}, specs[0].Headers)
+	s.Equal([]dynamicEndpointSpec{{Method: "GET", Url: "/login"}}, specs[0].Endpoints)
+	s.Equal(matchPrefix, specs[1].Endpoints[0].Match)
+	s.Equal(503, specs[1].Responses[0].Status)
+
+	specs, err = parseEndpointsFile([]byte(`[{"endpoints": [{"method": "GET", "url": "/json"}], "body": "json"}]`))
+	s.Require().NoError(err)
+	s.Equal("json", specs[0].Body)
+
+	specs, err = parseEndpointsFile([]byte("# nothing yet\n"))
+	s.Require().NoError(err)
+	s.Empty(specs)
+
+	_, err = parseEndpointsFile([]byte(`[{"stauts": 200}]`))
+	s.ErrorContains(err, "unknown field")
+	_, err = parseEndpointsFile([]byte(`[{}, {"endpoints": [{"method": "GET", "url": "/", "match": "fuzzy"}]}]`))
+	s.EqualError(err, "specification 1: invalid match type 'fuzzy'")
+}
+
+func (s *persistenceTestSuite) TestLoadEndpointsFile() {
+	path := s.writeFile("endpoints.yaml", "- endpoints: [{method: GET, url: /preloaded}]\n  status: 202\n")
+	albedo := New(WithEndpointsFile(path, false))
+	s.Require().NoError(albedo.loadEndpointsFile())
+
+	endpoint, ok := s.lookup(albedo, "GET", "/preloaded")
+	s.Require().True(ok)
+	s.Equal(202, endpoint.reflection.Status)
+}
+
+func (s *persistenceTestSuite) TestLoadEndpointsFile_Missing() {
+	path := filepath.Join(s.directory, "missing.yaml")
+	s.Error(New(WithEndpointsFile(path, false)).loadEndpointsFile())
+	// created on the first change in persistence mode
+	s.NoError(New(WithEndpointsFile(path, true)).loadEndpointsFile())
+}
+
+func (s *persistenceTestSuite) TestLoadEndpointsFile_Conflict() {
+	path := s.writeFile("endpoints.json", `[{"endpoints": [
+		{"method": "any", "url": "/users/admin", "match": "pattern"},
+		{"method": "GET", "url": "/users/{id}", "match": "pattern"}
+	]}]`)
+	s.ErrorContains(New(WithEndpointsFile(path, false)).loadEndpointsFile(), "invalid pattern")
+}
+
+func (s *persistenceTestSuite) TestServe_InvalidEndpointsFile() {
+	path := s.writeFile("endpoints.yaml", "not: a list")
+	err := New(WithEndpointsFile(path, false)).Serve(context.Background(), &ServeConfig{Binding: "127.0.0.1"})
+	s.ErrorContains(err, "invalid endpoints file")
+}
+
+func (s *persistenceTestSuite) TestReloadEndpointsFile() {
+	path := s.writeFile("endpoints.yaml", "- endpoints: [{method: GET, url: /first}]\n")
+	albedo := New(WithEndpointsFile(path, false))
+	s.Require().NoError(albedo.loadEndpointsFile())
+
+	s.writeFile("endpoints.yaml", "- endpoints: [{method: GET, url: /second}]\n")
+	albedo.reloadEndpointsFile()
+	_, ok := s.lookup(albedo, "GET", "/first")
+	s.False(ok)
+	_, ok = s.lookup(albedo, "GET", "/second")
+	s.True(ok)
+
+	// invalid changes keep the current configuration
+	s.writeFile("endpoints.yaml", "- endpoints: [{method: GET, url: /third, match: fuzzy}]\n")
+	albedo.reloadEndpointsFile()
+	_, ok = s.lookup(albedo, "GET", "/second")
+	s.True(ok)
+}
+
+func (s *persistenceTestSuite) TestPersist() {
+	for _, name := range []string{"endpoints.json", "endpoints.yaml"} {
+		path := filepath.Join(s.directory, name)
+		albedo := New(WithEndpointsFile(path, true))
+		s.Require().NoError(albedo.loadEndpointsFile())
+		server := httptest.NewServer(albedo.Handler())
+		s.T().Cleanup(server.Close)
+
+		spec := &configureReflectionSpec{
+			reflectionSpec: reflectionSpec{Status: 418, Body: "teapot"},
+			Endpoints: []dynamicEndpointSpec{
+				{Method: "GET", Url: "/tea"},
+				{Method: "POST", Url: "/coffee/", Match: matchPrefix},
+			},
+		}
+		body, err := json.Marshal(spec)
+		s.Require().NoError(err)
+		response, err := http.Post(server.URL+"/configure_reflection", "application/json", bytes.NewReader(body))
+		s.Require().NoError(err)
+		s.Require().NoError(response.Body.Close())
+		s.Require().Equal(http.StatusOK, response.StatusCode)
+
+		content, err := os.ReadFile(path)
+		s.Require().NoError(err)
+		specs, err := parseEndpointsFile(content)
+		s.Require().NoError(err, name)
+		s.Require().Len(specs, 2, name)
+		s.Equal([]dynamicEndpointSpec{{Method: "GET", Url: "/tea"}}, specs[0].Endpoints)
+		s.Equal([]dynamicEndpointSpec{{Method: "POST", Url: "/coffee/", Match: matchPrefix}}, specs[1].Endpoints)
+		s.Equal(418, specs[1].Status)
+		s.Equal("teapot", specs[1].Body)
+
+		// a fresh instance starts with the persisted configuration
+		restarted := New(WithEndpointsFile(path, true))
+		s.Require().NoError(restarted.loadEndpointsFile())
+		_, ok := s.lookup(restarted, "POST", "/coffee/beans")
+		s.True(ok)
+
+		// own writes don't trigger a reload, which would reset hit counters
+		endpoint, ok := s.lookup(albedo, "GET", "/tea")
+		s.Require().True(ok)
+		endpoint.hit()
+		albedo.reloadEndpointsFile()
+		endpoint, ok = s.lookup(albedo, "GET", "/tea")
+		s.Require().True(ok)
+		s.Equal(uint64(1), endpoint.status().Hits)
+
+		request, err := http.NewRequest(http.MethodPut, server.URL+"/reset", nil)
+		s.Require().NoError(err)
+		response, err = http.DefaultClient.Do(request)
+		s.Require().NoError(err)
+		s.Require().NoError(response.Body.Close())
+		content, err = os.ReadFile(path)
+		s.Require().NoError(err)
+		specs, err = parseEndpointsFile(content)
+		s.Require().NoError(err)
+		s.Empty(specs)
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(s.directory)
+	s.Require().NoError(err)
+	s.Len(entries, 2)
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPredicateBodySize is the number of body bytes available to body
+// predicates.
+const maxPredicateBodySize = 1024 * 1024
+
+// valueMatcher matches a value against a set of conditions. All configured
+// conditions must hold. A matcher without conditions matches every value.
+type valueMatcher struct {
+	name     string
+	equals   string
+	contains string
+	regex    *regexp.Regexp
+}
+
+func newValueMatcher(name string, conditions *valueConditions) (*valueMatcher, error) {
+	matcher := &valueMatcher{
+		name:     name,
+		equals:   conditions.Equals,
+		contains: conditions.Contains,
+	}
+	if conditions.Regex != "" {
+		regex, err := regexp.Compile(conditions.Regex)
+		if err != nil {
+			return nil, fieldErrorf("regex", "invalid regular expression '%s': %w", conditions.Regex, err)
+		}
+		matcher.regex = regex
+	}
+	return matcher, nil
+}
+
+func (m *valueMatcher) matches(value string) bool {
+	if m.equals != "" && value != m.equals {
+		return false
+	}
+	if m.contains != "" && !strings.Contains(value, m.contains) {
+		return false
+	}
+	if m.regex != nil && !m.regex.MatchString(value) {
+		return false
+	}
+	return true
+}
+
+// matchesAny reports whether any of the values matches. A matcher never
+// matches an absent value.
+func (m *valueMatcher) matchesAny(values []string) bool {
+	for _, value := range values {
+		if m.matches(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestPredicates select requests by their content, in addition to method
+// and URL. All predicates must hold.
+type requestPredicates struct {
+	headers     []*valueMatcher
+	query       []*valueMatcher
+	body        *valueMatcher
+	jsonPath    []jsonPathStep
+	contentType string
+}
+
+// compilePredicates compiles the request predicates of the endpoint. It
+// returns nil if the endpoint has no predicates.
+func compilePredicates(spec *dynamicEndpointSpec) (*requestPredicates, error) {
+	if len(spec.Headers) == 0 && len(spec.Query) == 0 && spec.Body == nil && spec.ContentType == "" {
+		return nil, nil
+	}
+
+	predicates := &requestPredicates{contentType: spec.ContentType}
+	for i, header := range spec.Headers {
+		if header.Name == "" {
+			return nil, fieldErrorf(fmt.Sprintf("headers[%d].name", i), "header predicates require a name")
+		}
+		matcher, err := newValueMatcher(header.Name, &header.valueConditions)
+		if err != nil {
+			return nil, nestField(fmt.Sprintf("headers[%d]", i), err)
+		}
+		predicates.headers = append(predicates.headers, matcher)
+	}
+	for i, parameter := range spec.Query {
+		if parameter.Name == "" {
+			return nil, fieldErrorf(fmt.Sprintf("query[%d].name", i), "query predicates require a name")
+		}
+		matcher, err := newValueMatcher(parameter.Name, &parameter.valueConditions)
+		if err != nil {
+			return nil, nestField(fmt.Sprintf("query[%d]", i), err)
+		}
+		predicates.query = append(predicates.query, matcher)
+	}
+	if spec.Body != nil {
+		matcher, err := newValueMatcher("", &spec.Body.valueConditions)
+		if err != nil {
+			return nil, nestField("body", err)
+		}
+		predicates.body = matcher
+		if spec.Body.JSONPath != "" {
+			predicates.jsonPath, err = parseJSONPath(spec.Body.JSONPath)
+			if err != nil {
+				return nil, nestField("body.jsonPath", err)
+			}
+		}
+	}
+	return predicates, nil
+}
+
+func (p *requestPredicates) matches(r *http.Request, body []byte) bool {
+	if p == nil {
+		return true
+	}
+
+	if p.contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !strings.EqualFold(mediaType, p.contentType) {
+			return false
+		}
+	}
+	for _, header := range p.headers {
+		if !header.matchesAny(r.Header.Values(header.name)) {
+			return false
+		}
+	}
+	if len(p.query) > 0 {
+		query := r.URL.Query()
+		for _, parameter := range p.query {
+			if !parameter.matchesAny(query[parameter.name]) {
+				return false
+			}
+		}
+	}
+	if p.body != nil {
+		if p.jsonPath == nil {
+			return p.body.matches(string(body))
+		}
+		var document any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return false
+		}
+		return p.body.matchesAny(evaluateJSONPath(p.jsonPath, document))
+	}
+	return true
+}
+
+// jsonPathStep is a single step of a JSONPath expression: a member name, an
+// array index, or a wildcard if both are unset.
+type jsonPathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the subset of JSONPath consisting of the root "$",
+// member access (".name" or "['name']"), array indices ("[0]") and
+// wildcards (".*" or "[*]").
+func parseJSONPath(expression string) ([]jsonPathStep, error) {
+	invalid := fmt.Errorf("invalid JSONPath '%s'", expression)
+	if !strings.HasPrefix(expression, "$") {
+		return nil, invalid
+	}
+	steps := []jsonPathStep{}
+	rest := expression[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, invalid
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{name: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case selector == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				steps = append(steps, jsonPathStep{name: selector[1 : len(selector)-1]})
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil {
+					return nil, invalid
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			return nil, invalid
+		}
+	}
+	return steps, nil
+}
+
+// evaluateJSONPath returns the string representations of all values selected
+// by the steps. Strings are returned as is, all other values in their JSON
+// encoding.
+func evaluateJSONPath(steps []jsonPathStep, document any) []string {
+	nodes := []any{document}
+	for _, step := range steps {
+		next := []any{}
+		for _, node := range nodes {
+			switch value := node.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, child := range value {
+						next = append(next, child)
+					}
+				} else if child, ok := value[step.name]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []any:
+				if step.wildcard {
+					next = append(next, value...)
+				} else if step.isIndex {
+					index := step.index
+					if index < 0 {
+						index += len(value)
+					}
+					if index >= 0 && index < len(value) {
+						next = append(next, value[index])
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+
+	values := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if text, ok := node.(string); ok {
+			values = append(values, text)
+			continue
+		}
+		encoded, err := json.Marshal(node)
+		if err == nil {
+			values = append(values, string(encoded))
+		}
+	}
+	return values
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type predicatesTestSuite struct {
+	suite.Suite
+}
+
+func TestPredicatesTestSuite(t *testing.T) {
+	suite.Run(t, new(predicatesTestSuite))
+}
+
+func (s *predicatesTestSuite) TestParseJSONPath() {
+	steps, err := parseJSONPath("$.a['b c'][2].*[*]")
+	s.Require().NoError(err)
+	s.Equal([]jsonPathStep{
+		{name: "a"},
+		{name: "b c"},
+		{index: 2, isIndex: true},
+		{wildcard: true},
+		{wildcard: true},
+	}, steps)
+
+	for _, expression := range []string{"a.b", "$..a", "$[x]", "$[0", "$a"} {
+		_, err := parseJSONPath(expression)
+		s.Error(err, expression)
+	}
+}
+
+func (s *predicatesTestSuite) TestEvaluateJSONPath() {
+	document := map[string]any{
+		"user": map[string]any{
+			"name":  "admin",
+			"roles": []any{"reader", "writer"},
+			"age":   42,
+		},
+	}
+	evaluate := func(expression string) []string {
+		steps, err := parseJSONPath(expression)
+		s.Require().NoError(err)
+		return evaluateJSONPath(steps, document)
+	}
+	s.Equal([]string{"admin"}, evaluate("$.user.name"))
+	s.Equal([]string{"writer"}, evaluate("$.user.roles[-1]"))
+	s.Equal([]string{"reader", "writer"}, evaluate("$.user.roles[*]"))
+	s.Equal([]string{"42"}, evaluate("$.user.age"))
+	s.Equal([]string{`["reader","writer"]`}, evaluate("$['user']['roles']"))
+	s.Empty(evaluate("$.user.missing"))
+	s.Empty(evaluate("$.user.roles.name"))
+}
+
+func (s *predicatesTestSuite) TestMatches() {
+	spec := &dynamicEndpointSpec{
+		Headers: []valueMatcherSpec{
+			{Name: "X-Marker", valueConditions: valueConditions{Equals: "leak"}},
+			{Name: "User-Agent", valueConditions: valueConditions{Regex: "^ftw/"}},
+		},
+		Query: []valueMatcherSpec{
+			{Name: "q", valueConditions: valueConditions{Contains: "script"}},
+		},
+		Body:        &bodyMatcherSpec{JSONPath: "$.id", valueConditions: valueConditions{Equals: "7"}},
+		ContentType: "application/json",
+	}
+	predicates, err := compilePredicates(spec)
+	s.Require().NoError(err)
+
+	newRequest := func(header string, contentType string) *http.Request {
+		request, err := http.NewRequest("POST", "/?q=%3Cscript%3E", strings.NewReader(""))
+		s.Require().NoError(err)
+		request.Header.Add("X-Marker", header)
+		request.Header.Add("User-Agent", "ftw/1.0")
+		request.Header.Add("Content-Type", contentType)
+		return request
+	}
+	s.True(predicates.matches(newRequest("leak", "application/json; charset=utf-8"), []byte(`{"id": 7}`)))
+	s.False(predicates.matches(newRequest("other", "application/json"), []byte(`{"id": 7}`)))
+	s.False(predicates.matches(newRequest("leak", "text/plain"), []byte(`{"id": 7}`)))
+	s.False(predicates.matches(newRequest("leak", "application/json"), []byte(`{"id": 8}`)))
+	s.False(predicates.matches(newRequest("leak", "application/json"), []byte(`not json`)))
+}
+
+func (s *predicatesTestSuite) TestNoPredicates() {
+	predicates, err := compilePredicates(&dynamicEndpointSpec{Method: "GET", Url: "/"})
+	s.Require().NoError(err)
+	s.Nil(predicates)
+	s.True(predicates.matches(&http.Request{}, nil))
+}
+
+func (s *predicatesTestSuite) TestInvalid() {
+	for _, spec := range []*dynamicEndpointSpec{
+		{Headers: []valueMatcherSpec{{valueConditions: valueConditions{Equals: "x"}}}},
+		{Query: []valueMatcherSpec{{Name: "q", valueConditions: valueConditions{Regex: "("}}}},
+		{Body: &bodyMatcherSpec{JSONPath: "id"}},
+	} {
+		_, err := compilePredicates(spec)
+		s.Error(err)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type connectionContextKey struct{}
+type requestContextKey struct{}
+type h2cContextKey struct{}
+
+// connectionInfo describes a connection accepted by one of the listeners
+// started by Serve.
+type connectionInfo struct {
+	id       uint64
+	requests atomic.Uint64
+	wire     *wireRecorder
+}
+
+// requestInfo holds information about a request that is not available
+// from the request itself.
+type requestInfo struct {
+	protocol *protocolInfo
+	headers  []headerField
+}
+
+// protocolInfo describes how a request was transported.
+type protocolInfo struct {
+	// Proto is the protocol version as reported by net/http, e.g. "HTTP/2.0".
+	Proto string `json:"proto"`
+	// TLS is true if the request was received over TLS.
+	TLS bool `json:"tls"`
+	// ALPN is the protocol negotiated through ALPN, if any.
+	ALPN string `json:"alpn,omitempty"`
+	// H2C is true for HTTP/2 requests received over cleartext connections.
+	H2C bool `json:"h2c"`
+	// Connection identifies the connection the request was received on.
+	// It is 0 if the request was not received by a listener started by Serve.
+	Connection uint64 `json:"connection,omitempty"`
+	// Sequence is the 1-based sequence number of the request on its
+	// connection. It is not the HTTP/2 stream identifier, which net/http
+	// does not expose; for HTTP/2 this is the order in which streams reached
+	// the handler.
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// connContext tags every accepted connection with a connectionInfo, so that
+// requests can be attributed to their connection.
+func (a *Albedo) connContext(ctx context.Context, conn net.Conn) context.Context {
+	info := &connectionInfo{id: a.connections.Add(1)}
+	if wire, ok := conn.(*wireRecorder); ok {
+		info.wire = wire
+	}
+	return context.WithValue(ctx, connectionContextKey{}, info)
+}
+
+// withRequestInfo assigns each request its sequence number on the
+// connection, recovers the headers as received and stores the resulting
+// requestInfo in the request context.
+func withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{protocol: newProtocolInfo(r)}
+		var wire *wireRecorder
+		if connection, ok := r.Context().Value(connectionContextKey{}).(*connectionInfo); ok {
+			info.protocol.Connection = connection.id
+			info.protocol.Sequence = connection.requests.Add(1)
+			wire = connection.wire
+		}
+		info.headers = requestHeaders(r, wire)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestContextKey{}, info)))
+	})
+}
+
+// getProtocolInfo returns the protocol information of the request. Requests
+// that did not pass through withRequestInfo only carry the information
+// available on the request itself.
+func getProtocolInfo(r *http.Request) *protocolInfo {
+	if info, ok := r.Context().Value(requestContextKey{}).(*requestInfo); ok {
+		return info.protocol
+	}
+	return newProtocolInfo(r)
+}
+
+// getRequestHeaders returns the headers of the request, as received if
+// possible.
+func getRequestHeaders(r *http.Request) []headerField {
+	if info, ok := r.Context().Value(requestContextKey{}).(*requestInfo); ok {
+		return info.headers
+	}
+	return requestHeaders(r, nil)
+}
+
+func newProtocolInfo(r *http.Request) *protocolInfo {
+	info := &protocolInfo{
+		Proto: r.Proto,
+		TLS:   r.TLS != nil,
+		H2C:   r.ProtoMajor == 2 && r.TLS == nil,
+	}
+	if r.TLS != nil {
+		info.ALPN = r.TLS.NegotiatedProtocol
+	}
+	// The HTTP/2 server serves the request that triggered an h2c upgrade on
+	// stream 1 but passes the original HTTP/1.1 request to the handler.
+	if r.ProtoMajor == 1 && r.Context().Value(h2cContextKey{}) != nil && isH2CUpgrade(r.Header) {
+		info.Proto = "HTTP/2.0"
+		info.H2C = true
+	}
+	return info
+}
+
+// withH2C accepts cleartext HTTP/2 connections, both with prior knowledge
+// and through the HTTP/1.1 upgrade mechanism.
+func withH2C(next http.Handler) http.Handler {
+	handler := h2c.NewHandler(next, &http2.Server{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), h2cContextKey{}, true)))
+	})
+}
+
+// isH2CUpgrade mirrors the check h2c uses to decide whether to upgrade a
+// connection.
+func isH2CUpgrade(header http.Header) bool {
+	return httpguts.HeaderValuesContainsToken(header.Values("Upgrade"), "h2c") &&
+		httpguts.HeaderValuesContainsToken(header.Values("Connection"), "HTTP2-Settings")
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/http2"
+)
+
+type protocolTestSuite struct {
+	suite.Suite
+	logOutput *syncBuffer
+	albedo    *Albedo
+}
+
+// syncBuffer is a bytes.Buffer that can be written to from handler
+// goroutines while the test reads it.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestProtocolTestSuite(t *testing.T) {
+	suite.Run(t, new(protocolTestSuite))
+}
+
+func (s *protocolTestSuite) SetupTest() {
+	s.logOutput = &syncBuffer{}
+	logger := slog.New(slog.NewTextHandler(s.logOutput, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	s.albedo = New(WithLogger(logger))
+}
+
+func (s *protocolTestSuite) newH2CServer() *httptest.Server {
+	server := httptest.NewUnstartedServer(withH2C(s.albedo.Handler()))
+	server.Config.ConnContext = s.albedo.connContext
+	server.Start()
+	s.T().Cleanup(server.Close)
+	return server
+}
+
+func (s *protocolTestSuite) TestHTTP1() {
+	server := s.newH2CServer()
+
+	client := http.Client{}
+	response, err := client.Get(server.URL + "/inspect")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal(1, response.ProtoMajor)
+
+	logContent := s.logOutput.String()
+	s.Contains(logContent, "request.protocol=HTTP/1.1")
+	s.Contains(logContent, "request.transport.tls=false")
+	s.Contains(logContent, "request.transport.h2c=false")
+	s.Contains(logContent, "request.transport.connection=1")
+	s.Contains(logContent, "request.transport.sequence=1")
+}
+
+func (s *protocolTestSuite) TestH2CPriorKnowledge() {
+	server := s.newH2CServer()
+
+	client := http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+	for range 2 {
+		response, err := client.Get(server.URL + "/inspect")
+		s.Require().NoError(err)
+		s.Equal(http.StatusOK, response.StatusCode)
+		s.Equal(2, response.ProtoMajor)
+	}
+
+	logContent := s.logOutput.String()
+	s.Contains(logContent, "request.protocol=HTTP/2.0")
+	s.Contains(logContent, "request.transport.h2c=true")
+	s.Contains(logContent, "request.transport.connection=1 request.transport.sequence=1")
+	s.Contains(logContent, "request.transport.connection=1 request.transport.sequence=2")
+}
+
+func (s *protocolTestSuite) TestH2CUpgrade() {
+	server := s.newH2CServer()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	s.Require().NoError(err)
+	defer conn.Close()
+
+	settings := base64.RawURLEncoding.EncodeToString([]byte{})
+	_, err = conn.Write([]byte("GET /inspect HTTP/1.1\r\n" +
+		"Host: " + server.Listener.Addr().String() + "\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: " + settings + "\r\n\r\n"))
+	s.Require().NoError(err)
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	s.Require().NoError(err)
+	s.Equal(http.StatusSwitchingProtocols, response.StatusCode)
+	s.Equal("h2c", response.Header.Get("Upgrade"))
+
+	// complete the HTTP/2 handshake so that the upgraded request is served
+	framer := http2.NewFramer(conn, conn)
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	s.Require().NoError(err)
+	s.Require().NoError(framer.WriteSettings())
+	s.Eventually(func() bool {
+		return strings.Contains(s.logOutput.String(), "request.protocol=HTTP/2.0")
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Contains(s.logOutput.String(), "request.transport.h2c=true request.transport.connection=1 request.transport.sequence=1")
+}
+
+func (s *protocolTestSuite) TestH2OverTLS() {
+	config, err := s.albedo.newTLSConfig(&TLSConfig{SelfSigned: true})
+	s.Require().NoError(err)
+	server := httptest.NewUnstartedServer(s.albedo.Handler())
+	server.TLS = config
+	server.EnableHTTP2 = true
+	server.Config.ConnContext = s.albedo.connContext
+	server.StartTLS()
+	s.T().Cleanup(server.Close)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	response, err := client.Get(server.URL + "/inspect")
+	s.Require().NoError(err)
+	s.Equal(2, response.ProtoMajor)
+
+	logContent := s.logOutput.String()
+	s.Contains(logContent, "request.protocol=HTTP/2.0")
+	s.Contains(logContent, "request.transport.tls=true")
+	s.Contains(logContent, "request.transport.h2c=false")
+	s.Contains(logContent, "request.transport.alpn=h2")
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func decodeRawResponse(spec *reflectionSpec) ([]byte, error) {
+	response, err := base64.StdEncoding.DecodeString(spec.RawResponse)
+	if err != nil {
+		return nil, fieldErrorf("rawResponse", "invalid base64 encoding of raw response")
+	}
+	return response, nil
+}
+
+// doReflectRaw writes the decoded raw response of the specification verbatim
+// to the connection of the request, bypassing net/http entirely. The
+// connection is closed afterwards, as the framing of the raw response is
+// unknown.
+func (a *Albedo) doReflectRaw(w http.ResponseWriter, r *http.Request, spec *reflectionSpec) {
+	response, err := decodeRawResponse(spec)
+	if err != nil {
+		a.writeReflectionError(w, err)
+		return
+	}
+
+	conn, buffer, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		if errors.Is(err, http.ErrNotSupported) {
+			a.writeError(w, http.StatusBadRequest, errorCodeUnsupported, fieldErrorf("rawResponse", "raw responses are not supported for %s", r.Proto))
+		} else {
+			a.log().Warn("Failed to hijack connection", "error", err.Error())
+		}
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			a.log().Warn("Failed to close connection", "error", err.Error())
+		}
+	}()
+
+	logResponse := string(response)
+	if len(logResponse) > 200 {
+		logResponse = logResponse[:200] + "..."
+	}
+	a.log().Info(fmt.Sprintf("Reflecting raw response %q", logResponse))
+	if _, err = buffer.Write(response); err == nil {
+		err = buffer.Flush()
+	}
+	if err != nil {
+		a.log().Warn("Failed to write raw response", "error", err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type rawTestSuite struct {
+	serverSuite
+}
+
+func TestRawTestSuite(t *testing.T) {
+	suite.Run(t, new(rawTestSuite))
+}
+
+func (s *rawTestSuite) SetupTest() {
+	s.serve()
+}
+
+func (s *rawTestSuite) send(path string, body []byte) string {
+	conn := s.dial()
+
+	request, err := http.NewRequest(http.MethodPost, s.server.URL+path, bytes.NewReader(body))
+	s.Require().NoError(err)
+	s.Require().NoError(request.Write(conn))
+	// the server closes the connection after the raw response
+	response, err := io.ReadAll(bufio.NewReader(conn))
+	s.Require().NoError(err)
+	return string(response)
+}
+
+func (s *rawTestSuite) TestRawResponse() {
+	raw := "HTTP/1.1 999 Broken\nContent-Length: 3\nContent-Length: 5\nX-Folded: a\r\n  b\r\n\r\nabcHTTP/1.1 200 OK\r\n\r\n"
+	spec := &reflectionSpec{
+		Status:      200,
+		Headers:     map[string]string{"X-Ignored": "true"},
+		RawResponse: base64.StdEncoding.EncodeToString([]byte(raw)),
+	}
+	body, err := json.Marshal(spec)
+	s.Require().NoError(err)
+
+	s.Equal(raw, s.send("/reflect", body))
+}
+
+func (s *rawTestSuite) TestRawResponse_ConfiguredEndpoint() {
+	raw := "HTTP/1.0 200 OK\n\nraw"
+	spec := &configureReflectionSpec{
+		reflectionSpec: reflectionSpec{RawResponse: base64.StdEncoding.EncodeToString([]byte(raw))},
+		Endpoints:      []dynamicEndpointSpec{{Method: "POST", Url: "/raw"}},
+	}
+	s.Require().NoError(s.albedo.global.endpoints.configure(spec))
+
+	s.Equal(raw, s.send("/raw", nil))
+}
+
+func (s *rawTestSuite) TestRawResponse_InvalidEncoding() {
+	response, body := s.doJSON("POST", "/reflect", &reflectionSpec{RawResponse: "!"})
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.Equal(errorCodeInvalidSpecification, response.Header.Get(errorHeader))
+	s.JSONEq(`{"code": "invalid_specification", "message": "invalid base64 encoding of raw response", "field": "rawResponse"}`, body)
+}
+
+func (s *rawTestSuite) TestRawResponse_InvalidEncoding_Configuration() {
+	response, body := s.do("POST", "/configure_reflection",
+		`{"rawResponse": "!", "endpoints": [{"method": "POST", "url": "/raw"}]}`)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "invalid_specification", "message": "invalid base64 encoding of raw response", "field": "rawResponse"}`, body)
+	s.Zero(s.albedo.global.endpoints.len())
+}
+
+func (s *rawTestSuite) TestRawResponse_HTTP2() {
+	server := httptest.NewUnstartedServer(s.albedo.Handler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	s.T().Cleanup(server.Close)
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	body, err := json.Marshal(&reflectionSpec{RawResponse: base64.StdEncoding.EncodeToString([]byte("HTTP/1.1 200 OK\r\n\r\n"))})
+	s.Require().NoError(err)
+	response, responseBody := doRequest(s.T(), &client, "POST", server.URL+"/reflect", string(body), nil)
+	s.Equal(2, response.ProtoMajor)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "unsupported", "message": "raw responses are not supported for HTTP/2.0", "field": "rawResponse"}`, responseBody)
+}
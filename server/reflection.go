@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// reflection is a decoded reflection specification, ready to be written.
+type reflection struct {
+	status int
+	body   []byte
+	// chunks holds explicit body segments. If set, body is not used.
+	chunks   [][]byte
+	chunked  bool
+	trailers map[string]string
+	// contentEncoding is the value of the Content-Encoding header to set, if
+	// not empty
+	contentEncoding string
+	timing          *responseTiming
+	fault           *faultSpec
+}
+
+func (a *Albedo) newReflection(spec *reflectionSpec, status int) (*reflection, error) {
+	responseBody, err := a.decodeBody(spec)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := decodeChunks(spec)
+	if err != nil {
+		return nil, err
+	}
+	trailers, err := decodeTrailers(spec)
+	if err != nil {
+		return nil, err
+	}
+	timing, err := parseResponseTiming(spec)
+	if err != nil {
+		return nil, err
+	}
+	body := []byte(responseBody)
+	contentEncoding := ""
+	if spec.Compress != "" {
+		if err := validateCompression(spec); err != nil {
+			return nil, err
+		}
+		if body, contentEncoding, err = compressBody(body, spec.Compress); err != nil {
+			return nil, nestField("compress", err)
+		}
+	}
+	if spec.ContentEncoding != "" {
+		// deliberately lie about the encoding
+		contentEncoding = spec.ContentEncoding
+	}
+	response := &reflection{
+		status:          status,
+		body:            body,
+		chunks:          chunks,
+		chunked:         spec.Chunked || len(chunks) > 0,
+		trailers:        trailers,
+		contentEncoding: contentEncoding,
+		timing:          timing,
+		fault:           spec.Fault,
+	}
+	if response.fault != nil {
+		if err := validateFault(response.fault, response.bodySize()); err != nil {
+			return nil, err
+		}
+		if response.fault.Mode == faultTruncate {
+			// the declared Content-Length rules out chunked encoding
+			response.chunked = false
+			clear(response.trailers)
+		}
+	}
+	return response, nil
+}
+
+func decodeChunks(spec *reflectionSpec) ([][]byte, error) {
+	chunks := [][]byte{}
+	if len(spec.Chunks) > 0 {
+		for _, chunk := range spec.Chunks {
+			chunks = append(chunks, []byte(chunk))
+		}
+		return chunks, nil
+	}
+	for i, encodedChunk := range spec.EncodedChunks {
+		chunk, err := base64.StdEncoding.DecodeString(encodedChunk)
+		if err != nil {
+			return nil, fieldErrorf(fmt.Sprintf("encodedChunks[%d]", i), "invalid base64 encoding of chunk %d", i)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func decodeTrailers(spec *reflectionSpec) (map[string]string, error) {
+	trailers := map[string]string{}
+	for name, value := range spec.Trailers {
+		trailers[name] = value
+	}
+	for name, encodedValue := range spec.EncodedTrailers {
+		value, err := base64.StdEncoding.DecodeString(encodedValue)
+		if err != nil {
+			return nil, fieldErrorf("encodedTrailers."+name, "invalid base64 encoding of trailer '%s'", name)
+		}
+		trailers[name] = string(value)
+	}
+	return trailers, nil
+}
+
+func (r *reflection) bodySize() int {
+	if len(r.chunks) == 0 {
+		return len(r.body)
+	}
+	size := 0
+	for _, chunk := range r.chunks {
+		size += len(chunk)
+	}
+	return size
+}
+
+// segments returns the body split into the segments to write, each of which
+// is flushed separately.
+func (r *reflection) segments() [][]byte {
+	if len(r.chunks) > 0 {
+		return r.chunks
+	}
+	if len(r.body) == 0 {
+		return nil
+	}
+	if !r.timing.throttled() {
+		return [][]byte{r.body}
+	}
+	segments := [][]byte{}
+	for offset := 0; offset < len(r.body); offset += r.timing.chunkSize {
+		segments = append(segments, r.body[offset:min(offset+r.timing.chunkSize, len(r.body))])
+	}
+	return segments
+}
+
+// writeReflection writes status, body and trailers, honoring the response
+// timing. Delays are aborted when the client goes away.
+func (a *Albedo) writeReflection(w http.ResponseWriter, r *http.Request, response *reflection) {
+	// trailers must be declared before writing the header
+	trailerNames := make([]string, 0, len(response.trailers))
+	for name := range response.trailers {
+		trailerNames = append(trailerNames, name)
+	}
+	slices.Sort(trailerNames)
+	for _, name := range trailerNames {
+		w.Header().Add("Trailer", name)
+	}
+
+	if response.contentEncoding != "" {
+		a.log().Info(fmt.Sprintf("Reflecting Content-Encoding '%s'", response.contentEncoding))
+		w.Header().Set("Content-Encoding", response.contentEncoding)
+	}
+
+	if response.timing.headerDelay > 0 {
+		a.log().Info(fmt.Sprintf("Delaying headers by %s", response.timing.headerDelay))
+		if !sleepContext(r.Context(), response.timing.headerDelay) {
+			a.log().Info("Request canceled while delaying headers")
+			return
+		}
+	}
+	if response.fault != nil && a.injectFault(w, r, response) {
+		return
+	}
+	a.log().Info(fmt.Sprintf("Reflecting status '%d'", response.status))
+	w.WriteHeader(response.status)
+
+	controller := http.NewResponseController(w)
+	segments := response.segments()
+	// flushing the headers before the body has been written prevents
+	// net/http from setting Content-Length
+	if response.chunked || len(segments) > 0 && response.timing.bodyDelay > 0 {
+		if err := controller.Flush(); err != nil {
+			a.log().Warn("Failed to flush response headers", "error", err.Error())
+		}
+	}
+
+	if len(segments) > 0 {
+		responseBody := string(slices.Concat(segments...))
+		if len(responseBody) > 200 {
+			responseBody = responseBody[:min(len(responseBody), 200)] + "..."
+		}
+		a.log().Info(fmt.Sprintf("Reflecting body '%s'", responseBody))
+
+		if response.timing.bodyDelay > 0 {
+			a.log().Info(fmt.Sprintf("Delaying body by %s", response.timing.bodyDelay))
+			if !sleepContext(r.Context(), response.timing.bodyDelay) {
+				a.log().Info("Request canceled while delaying body")
+				return
+			}
+		}
+		if response.timing.throttled() {
+			a.log().Info(fmt.Sprintf("Writing %d body segments every %s", len(segments), response.timing.chunkInterval))
+		}
+		if err := writeSegments(r.Context(), w, segments, response.timing.chunkInterval); err != nil {
+			a.log().Warn("Failed to write response body", "error", err.Error())
+			return
+		}
+	}
+
+	for _, name := range trailerNames {
+		a.log().Info(fmt.Sprintf("Reflecting trailer '%s':'%s'", name, response.trailers[name]))
+		w.Header().Set(name, response.trailers[name])
+	}
+}
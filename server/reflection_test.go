@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type reflectionTestSuite struct {
+	serverSuite
+}
+
+func TestReflectionTestSuite(t *testing.T) {
+	suite.Run(t, new(reflectionTestSuite))
+}
+
+func (s *reflectionTestSuite) SetupTest() {
+	s.serve()
+}
+
+// reflectRaw returns the raw HTTP/1.1 response to a reflection request.
+func (s *reflectionTestSuite) reflectRaw(spec *reflectionSpec) string {
+	body, err := json.Marshal(spec)
+	s.Require().NoError(err)
+	conn := s.dial()
+
+	request, err := http.NewRequest(http.MethodPost, s.server.URL+"/reflect", bytes.NewReader(body))
+	s.Require().NoError(err)
+	request.Close = true
+	s.Require().NoError(request.Write(conn))
+	response, err := io.ReadAll(bufio.NewReader(conn))
+	s.Require().NoError(err)
+	return string(response)
+}
+
+func (s *reflectionTestSuite) TestChunked() {
+	response, body := s.doJSON("POST", "/reflect", &reflectionSpec{Body: "chunked body", Chunked: true})
+
+	s.Equal([]string{"chunked"}, response.TransferEncoding)
+	s.Equal(int64(-1), response.ContentLength)
+	s.Equal("chunked body", body)
+}
+
+func (s *reflectionTestSuite) TestNotChunkedByDefault() {
+	response, _ := s.doJSON("POST", "/reflect", &reflectionSpec{Body: "plain body"})
+
+	s.Empty(response.TransferEncoding)
+	s.Equal(int64(len("plain body")), response.ContentLength)
+}
+
+func (s *reflectionTestSuite) TestChunks() {
+	raw := s.reflectRaw(&reflectionSpec{Chunks: []string{"abc", "de", "f"}})
+
+	s.Contains(raw, "Transfer-Encoding: chunked\r\n")
+	s.True(strings.HasSuffix(raw, "\r\n\r\n3\r\nabc\r\n2\r\nde\r\n1\r\nf\r\n0\r\n\r\n"), raw)
+}
+
+func (s *reflectionTestSuite) TestEncodedChunks() {
+	_, body := s.doJSON("POST", "/reflect", &reflectionSpec{
+		Body: "ignored",
+		EncodedChunks: []string{
+			base64.StdEncoding.EncodeToString([]byte{0x00, 0x01}),
+			base64.StdEncoding.EncodeToString([]byte{0xff}),
+		},
+	})
+
+	s.Equal(string([]byte{0x00, 0x01, 0xff}), body)
+}
+
+func (s *reflectionTestSuite) TestTrailers() {
+	response, _ := s.doJSON("POST", "/reflect", &reflectionSpec{
+		Body:            "body",
+		Trailers:        map[string]string{"X-Checksum": "abc"},
+		EncodedTrailers: map[string]string{"X-Encoded": base64.StdEncoding.EncodeToString([]byte("dec\"oded"))},
+	})
+
+	s.Equal([]string{"chunked"}, response.TransferEncoding)
+	// the client moves declared trailers from the header to Trailer and
+	// fills in their values once the body has been read
+	s.Equal("abc", response.Trailer.Get("X-Checksum"))
+	s.Equal("dec\"oded", response.Trailer.Get("X-Encoded"))
+}
+
+func (s *reflectionTestSuite) TestTrailers_Raw() {
+	raw := s.reflectRaw(&reflectionSpec{Chunks: []string{"ab"}, Trailers: map[string]string{"X-Trailer": "value"}})
+
+	s.Contains(raw, "Trailer: X-Trailer\r\n")
+	s.True(strings.HasSuffix(raw, "\r\n\r\n2\r\nab\r\n0\r\nX-Trailer: value\r\n\r\n"), raw)
+}
+
+func (s *reflectionTestSuite) TestInvalidEncoding() {
+	for _, spec := range []reflectionSpec{
+		{EncodedChunks: []string{"!"}},
+		{EncodedTrailers: map[string]string{"X-Trailer": "!"}},
+	} {
+		response, _ := s.doJSON("POST", "/reflect", &spec)
+		s.Equal(http.StatusBadRequest, response.StatusCode)
+	}
+}
+
+func (s *reflectionTestSuite) TestInvalidEncoding_Configuration() {
+	for field, spec := range map[string]string{
+		"encodedChunks[1]":              `{"encodedChunks": ["YQ==", "!"]}`,
+		"encodedTrailers.X-Trailer":     `{"encodedTrailers": {"X-Trailer": "!"}}`,
+		"responses[0].encodedChunks[0]": `{"responses": [{"encodedChunks": ["!"]}]}`,
+	} {
+		response, body := s.do("POST", "/configure_reflection", spec)
+		s.Equal(http.StatusBadRequest, response.StatusCode, spec)
+		document := &errorDocument{}
+		s.Require().NoError(json.Unmarshal([]byte(body), document))
+		s.Equal(field, document.Field, spec)
+	}
+}
@@ -0,0 +1,692 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errEndpointNotFound = errors.New("endpoint not found")
+	errEndpointConflict = errors.New("an endpoint with the same method, URL, match type and request predicates exists")
+)
+
+// dynamicEndpoint is an endpoint configured through "/configure_reflection".
+type dynamicEndpoint struct {
+	// id identifies the endpoint in the management API, see endpointID
+	id      string
+	created time.Time
+	// expires is zero if the endpoint doesn't expire
+	expires      time.Time
+	expiresAfter string
+	// maxHits is 0 if the number of requests is unlimited
+	maxHits    uint64
+	endpoint   dynamicEndpointSpec
+	reflection reflectionSpec
+	// responses is the response sequence of the endpoint. If empty, the
+	// endpoint always responds with reflection.
+	responses []reflectionSpec
+	afterLast string
+	// order is the position of the endpoint in the configuration history,
+	// used to break ties between matching endpoints.
+	order      uint64
+	regex      *regexp.Regexp
+	pattern    *http.ServeMux
+	predicates *requestPredicates
+
+	mutex sync.Mutex
+	hits  uint64
+}
+
+// hit counts a request to the endpoint and returns the reflection to respond
+// with. It returns nil if the endpoint should respond as if it had not been
+// configured.
+func (e *dynamicEndpoint) hit() *reflectionSpec {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.maxHits > 0 && e.hits >= e.maxHits {
+		// used up concurrently since the lookup
+		return nil
+	}
+	index := e.hits
+	e.hits++
+
+	if len(e.responses) == 0 {
+		return &e.reflection
+	}
+	if index < uint64(len(e.responses)) {
+		return &e.responses[index]
+	}
+	switch e.afterLast {
+	case afterLastLoop:
+		return &e.responses[index%uint64(len(e.responses))]
+	case afterLastDefault:
+		return nil
+	default:
+		return &e.responses[len(e.responses)-1]
+	}
+}
+
+func (e *dynamicEndpoint) status() endpointStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	status := endpointStatus{
+		ID:        e.id,
+		Method:    e.endpoint.Method,
+		Url:       e.endpoint.Url,
+		Match:     e.endpoint.Match,
+		Hits:      e.hits,
+		Responses: len(e.responses),
+		Created:   e.created,
+		MaxHits:   e.maxHits,
+	}
+	if e.maxHits > 0 {
+		remaining := e.maxHits - min(e.hits, e.maxHits)
+		status.RemainingHits = &remaining
+	}
+	if !e.expires.IsZero() {
+		status.Expires = &e.expires
+	}
+	if len(e.responses) > 0 {
+		status.AfterLast = cmp.Or(e.afterLast, afterLastRepeat)
+	}
+	return status
+}
+
+// spec returns the configuration of the endpoint as if it had been
+// configured on its own.
+func (e *dynamicEndpoint) spec() configureReflectionSpec {
+	return configureReflectionSpec{
+		reflectionSpec: e.reflection,
+		Responses:      e.responses,
+		AfterLast:      e.afterLast,
+		ExpiresAfter:   e.expiresAfter,
+		MaxHits:        e.maxHits,
+		Endpoints:      []dynamicEndpointSpec{e.endpoint},
+	}
+}
+
+// available reports whether the endpoint has neither expired nor been used
+// up.
+func (e *dynamicEndpoint) available(now time.Time) bool {
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		return false
+	}
+	if e.maxHits == 0 {
+		return true
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.hits < e.maxHits
+}
+
+func (e *dynamicEndpoint) details() *endpointDetails {
+	return &endpointDetails{endpointStatus: e.status(), Configuration: e.spec()}
+}
+
+func (e *dynamicEndpoint) anyMethod() bool {
+	return strings.EqualFold(e.endpoint.Method, methodAny)
+}
+
+// matches reports whether the endpoint matches the request, including its
+// request predicates.
+func (e *dynamicEndpoint) matches(r *http.Request, body []byte) bool {
+	return e.matchesURL(r) && e.predicates.matches(r, body)
+}
+
+// matchesURL reports whether method and URL of the request match the
+// endpoint.
+func (e *dynamicEndpoint) matchesURL(r *http.Request) bool {
+	if e.endpoint.Match == matchPattern {
+		// the pattern includes the method
+		return matchingPattern(e.pattern, r) != ""
+	}
+	if !e.anyMethod() && e.endpoint.Method != r.Method {
+		return false
+	}
+	switch e.endpoint.Match {
+	case "", matchExact:
+		return r.RequestURI == e.endpoint.Url
+	case matchPrefix:
+		return strings.HasPrefix(r.URL.EscapedPath(), e.endpoint.Url)
+	case matchGlob:
+		matched, _ := path.Match(e.endpoint.Url, r.URL.EscapedPath())
+		return matched
+	case matchRegex:
+		return e.regex.MatchString(r.RequestURI)
+	}
+	return false
+}
+
+// patternHandler is registered for the patterns of dynamic endpoints. It is
+// never invoked and only identifies actual matches: ServeMux also returns a
+// pattern for requests it would redirect, e.g., "/files" for the pattern
+// "/files/".
+type patternHandler struct{}
+
+func (patternHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.NotFound(w, r)
+}
+
+// matchingPattern returns the pattern of mux matching the request, or an
+// empty string if no pattern matches.
+func matchingPattern(mux *http.ServeMux, r *http.Request) string {
+	handler, pattern := mux.Handler(r)
+	if handler != (patternHandler{}) {
+		return ""
+	}
+	return pattern
+}
+
+// endpointRegistry holds the dynamic endpoints configured through
+// "/configure_reflection". It is safe for concurrent use.
+//
+// When multiple endpoints match a request, the following rules apply in
+// order:
+//  1. exact matches win over all other match types
+//  2. patterns win over prefixes, globs and regular expressions; among
+//     patterns, the most specific pattern wins (see net/http.ServeMux)
+//  3. prefixes win over globs and regular expressions; among prefixes, the
+//     longest prefix wins
+//  4. globs win over regular expressions
+//  5. endpoints with a concrete method win over endpoints with method "any"
+//  6. endpoints configured earlier win over endpoints configured later
+//
+// Endpoints whose request predicates don't hold are skipped.
+type endpointRegistry struct {
+	mutex     sync.RWMutex
+	seed      maphash.Seed
+	endpoints map[uint64]*dynamicEndpoint
+	order     uint64
+
+	// derived from endpoints, see rebuild
+	exact            map[uint64][]*dynamicEndpoint
+	patterns         *http.ServeMux
+	patternEndpoints map[string][]*dynamicEndpoint
+	patternList      []*dynamicEndpoint
+	matchers         []*dynamicEndpoint
+}
+
+func newEndpointRegistry() *endpointRegistry {
+	return &endpointRegistry{
+		seed:             maphash.MakeSeed(),
+		endpoints:        map[uint64]*dynamicEndpoint{},
+		exact:            map[uint64][]*dynamicEndpoint{},
+		patterns:         http.NewServeMux(),
+		patternEndpoints: map[string][]*dynamicEndpoint{},
+	}
+}
+
+func (r *endpointRegistry) key(method string, url string) uint64 {
+	hash := maphash.Hash{}
+	hash.SetSeed(r.seed)
+	hash.WriteString(method)
+	hash.WriteString(url)
+	return hash.Sum64()
+}
+
+// endpointKey identifies an endpoint in the registry by method, URL, match
+// type and request predicates.
+func (r *endpointRegistry) endpointKey(spec *dynamicEndpointSpec) uint64 {
+	method := spec.Method
+	if strings.EqualFold(method, methodAny) {
+		method = methodAny
+	}
+	identity := *spec
+	identity.Method = ""
+	identity.Url = ""
+	if identity.Match == matchExact {
+		identity.Match = ""
+	}
+	// marshalling can't fail for the specification's types
+	fingerprint, _ := json.Marshal(identity)
+	return r.key(method+" "+string(fingerprint), spec.Url)
+}
+
+// lookup returns the endpoint matching the request. body holds the (possibly
+// partial) request body for body predicates.
+func (r *endpointRegistry) lookup(request *http.Request, body []byte) (*dynamicEndpoint, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	// expired and used up endpoints are skipped until they are evicted
+	now := time.Now()
+	for _, method := range []string{request.Method, methodAny} {
+		for _, endpoint := range r.exact[r.key(method, request.RequestURI)] {
+			if endpoint.available(now) && endpoint.predicates.matches(request, body) {
+				return endpoint, true
+			}
+		}
+	}
+	if len(r.patternList) > 0 {
+		// try the most specific pattern first, then all others
+		pattern := matchingPattern(r.patterns, request)
+		for _, endpoint := range r.patternEndpoints[pattern] {
+			if endpoint.available(now) && endpoint.predicates.matches(request, body) {
+				return endpoint, true
+			}
+		}
+		for _, endpoint := range r.patternList {
+			if endpoint.available(now) && endpoint.matches(request, body) {
+				return endpoint, true
+			}
+		}
+	}
+	for _, endpoint := range r.matchers {
+		if endpoint.available(now) && endpoint.matches(request, body) {
+			return endpoint, true
+		}
+	}
+	return nil, false
+}
+
+// validateReflectionSpec validates the parts of a reflection specification
+// that can be validated before the response is rendered.
+func validateReflectionSpec(spec *reflectionSpec) error {
+	if _, err := decodeEncodedBody(spec); err != nil {
+		return err
+	}
+	if _, err := decodeRawResponse(spec); err != nil {
+		return err
+	}
+	if _, err := decodeChunks(spec); err != nil {
+		return err
+	}
+	if _, err := decodeTrailers(spec); err != nil {
+		return err
+	}
+	if err := validateCompression(spec); err != nil {
+		return err
+	}
+	if _, err := parseResponseTiming(spec); err != nil {
+		return err
+	}
+	if err := validateTemplates(spec); err != nil {
+		return err
+	}
+	if err := validateEchoFormat(spec.EchoFormat); err != nil {
+		return nestField("echoFormat", err)
+	}
+	if spec.Fault != nil {
+		if err := validateFaultMode(spec.Fault); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateConfiguration(spec *configureReflectionSpec) error {
+	switch spec.AfterLast {
+	case "", afterLastRepeat, afterLastLoop, afterLastDefault:
+	default:
+		return fieldErrorf("afterLast", "invalid afterLast '%s'", spec.AfterLast)
+	}
+	if _, err := parseExpiresAfter(spec.ExpiresAfter); err != nil {
+		return err
+	}
+	if err := validateReflectionSpec(&spec.reflectionSpec); err != nil {
+		return err
+	}
+	for i := range spec.Responses {
+		if err := validateReflectionSpec(&spec.Responses[i]); err != nil {
+			return nestField(fmt.Sprintf("responses[%d]", i), fmt.Errorf("response %d: %w", i, err))
+		}
+	}
+	for i, _endpoint := range spec.Endpoints {
+		field := fmt.Sprintf("endpoints[%d]", i)
+		switch _endpoint.Match {
+		case "", matchExact, matchPattern, matchPrefix:
+		case matchGlob:
+			if _, err := path.Match(_endpoint.Url, ""); err != nil {
+				return fieldErrorf(field+".url", "invalid glob '%s'", _endpoint.Url)
+			}
+		case matchRegex:
+			if _, err := regexp.Compile(_endpoint.Url); err != nil {
+				return fieldErrorf(field+".url", "invalid regular expression '%s': %w", _endpoint.Url, err)
+			}
+		default:
+			return fieldErrorf(field+".match", "invalid match type '%s'", _endpoint.Match)
+		}
+		if _, err := compilePredicates(&_endpoint); err != nil {
+			return nestField(field, err)
+		}
+	}
+	return nil
+}
+
+// configure registers the endpoints of the specification, replacing
+// endpoints with the same method, URL, match type and request predicates.
+// Every endpoint starts with its own, fresh hit counter. The specification
+// must have been validated with validateConfiguration.
+func (r *endpointRegistry) configure(spec *configureReflectionSpec) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	endpoints := maps.Clone(r.endpoints)
+	order := r.add(endpoints, spec, r.order)
+	if err := r.rebuild(endpoints); err != nil {
+		return err
+	}
+	r.endpoints = endpoints
+	r.order = order
+	return nil
+}
+
+// replace discards all endpoints and registers the endpoints of the
+// specifications instead, as if they were configured in order. The
+// specifications must have been validated with validateConfiguration.
+func (r *endpointRegistry) replace(specs []configureReflectionSpec) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	endpoints := map[uint64]*dynamicEndpoint{}
+	order := r.order
+	for i := range specs {
+		order = r.add(endpoints, &specs[i], order)
+	}
+	if err := r.rebuild(endpoints); err != nil {
+		return err
+	}
+	r.endpoints = endpoints
+	r.order = order
+	return nil
+}
+
+// add adds the endpoints of the specification to endpoints, numbered
+// starting after order. It returns the order of the last endpoint added.
+func (r *endpointRegistry) add(endpoints map[uint64]*dynamicEndpoint, spec *configureReflectionSpec, order uint64) uint64 {
+	created := time.Now()
+	// validated by validateConfiguration
+	expiresAfter, _ := parseExpiresAfter(spec.ExpiresAfter)
+	for _, _endpoint := range spec.Endpoints {
+		order++
+		key := r.endpointKey(&_endpoint)
+		endpoint := &dynamicEndpoint{
+			id:           endpointID(key),
+			created:      created,
+			expiresAfter: spec.ExpiresAfter,
+			maxHits:      spec.MaxHits,
+			endpoint:     _endpoint,
+			reflection:   spec.reflectionSpec,
+			responses:    spec.Responses,
+			afterLast:    spec.AfterLast,
+			order:        order,
+		}
+		if expiresAfter > 0 {
+			endpoint.expires = created.Add(expiresAfter)
+		}
+		if _endpoint.Match == matchRegex {
+			endpoint.regex = regexp.MustCompile(_endpoint.Url)
+		}
+		// validated by validateConfiguration
+		endpoint.predicates, _ = compilePredicates(&_endpoint)
+		endpoints[key] = endpoint
+	}
+	return order
+}
+
+// rebuild derives the lookup structures from endpoints. The registry is
+// only modified if all patterns can be registered.
+func (r *endpointRegistry) rebuild(endpoints map[uint64]*dynamicEndpoint) (err error) {
+	exact := map[uint64][]*dynamicEndpoint{}
+	patterns := http.NewServeMux()
+	patternEndpoints := map[string][]*dynamicEndpoint{}
+	patternList := []*dynamicEndpoint{}
+	matchers := []*dynamicEndpoint{}
+
+	defer func() {
+		// ServeMux panics on invalid and conflicting patterns
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("invalid pattern: %v", recovered)
+		}
+	}()
+	ordered := make([]*dynamicEndpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		ordered = append(ordered, endpoint)
+	}
+	slices.SortFunc(ordered, func(a *dynamicEndpoint, b *dynamicEndpoint) int {
+		return cmp.Compare(a.order, b.order)
+	})
+	for _, endpoint := range ordered {
+		switch endpoint.endpoint.Match {
+		case "", matchExact:
+			method := endpoint.endpoint.Method
+			if endpoint.anyMethod() {
+				method = methodAny
+			}
+			key := r.key(method, endpoint.endpoint.Url)
+			exact[key] = append(exact[key], endpoint)
+		case matchPattern:
+			pattern := endpoint.endpoint.Url
+			if !endpoint.anyMethod() {
+				pattern = endpoint.endpoint.Method + " " + pattern
+			}
+			// endpoints that only differ in their predicates share a pattern
+			if _, ok := patternEndpoints[pattern]; !ok {
+				patterns.Handle(pattern, patternHandler{})
+			}
+			patternEndpoints[pattern] = append(patternEndpoints[pattern], endpoint)
+			if endpoint.pattern == nil {
+				endpoint.pattern = http.NewServeMux()
+				endpoint.pattern.Handle(pattern, patternHandler{})
+			}
+			patternList = append(patternList, endpoint)
+		case matchPrefix, matchGlob, matchRegex:
+			matchers = append(matchers, endpoint)
+		}
+	}
+
+	rank := map[string]int{matchPrefix: 0, matchGlob: 1, matchRegex: 2}
+	slices.SortStableFunc(matchers, func(a *dynamicEndpoint, b *dynamicEndpoint) int {
+		if c := cmp.Compare(rank[a.endpoint.Match], rank[b.endpoint.Match]); c != 0 {
+			return c
+		}
+		if a.endpoint.Match == matchPrefix {
+			if c := cmp.Compare(len(b.endpoint.Url), len(a.endpoint.Url)); c != 0 {
+				return c
+			}
+		}
+		if a.anyMethod() != b.anyMethod() {
+			if a.anyMethod() {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	})
+
+	r.exact = exact
+	r.patterns = patterns
+	r.patternEndpoints = patternEndpoints
+	r.patternList = patternList
+	r.matchers = matchers
+	return nil
+}
+
+// len returns the number of configured endpoints.
+func (r *endpointRegistry) len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.endpoints)
+}
+
+// list returns the status of all endpoints, ordered by URL and method.
+func (r *endpointRegistry) list() []endpointStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	statuses := make([]endpointStatus, 0, len(r.endpoints))
+	for _, endpoint := range r.endpoints {
+		statuses = append(statuses, endpoint.status())
+	}
+	slices.SortFunc(statuses, func(a endpointStatus, b endpointStatus) int {
+		return cmp.Or(cmp.Compare(a.Url, b.Url), cmp.Compare(a.Method, b.Method), cmp.Compare(a.Match, b.Match))
+	})
+	return statuses
+}
+
+// specs returns the configuration of all endpoints, one specification per
+// endpoint, in the order they were configured.
+func (r *endpointRegistry) specs() []configureReflectionSpec {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ordered := make([]*dynamicEndpoint, 0, len(r.endpoints))
+	for _, endpoint := range r.endpoints {
+		ordered = append(ordered, endpoint)
+	}
+	slices.SortFunc(ordered, func(a *dynamicEndpoint, b *dynamicEndpoint) int {
+		return cmp.Compare(a.order, b.order)
+	})
+	specs := make([]configureReflectionSpec, 0, len(ordered))
+	for _, endpoint := range ordered {
+		specs = append(specs, endpoint.spec())
+	}
+	return specs
+}
+
+// endpointID returns the ID of the endpoint with the given registry key.
+// IDs are stable while the process runs; an endpoint that is configured
+// again keeps its ID.
+func endpointID(key uint64) string {
+	return fmt.Sprintf("%016x", key)
+}
+
+func parseEndpointID(id string) (uint64, bool) {
+	if len(id) != 16 {
+		return 0, false
+	}
+	key, err := strconv.ParseUint(id, 16, 64)
+	return key, err == nil
+}
+
+// get returns the endpoint with the given ID.
+func (r *endpointRegistry) get(id string) (*dynamicEndpoint, bool) {
+	key, ok := parseEndpointID(id)
+	if !ok {
+		return nil, false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	endpoint, ok := r.endpoints[key]
+	return endpoint, ok
+}
+
+// remove removes the endpoint with the given ID and returns it. It returns
+// false if there is no such endpoint.
+func (r *endpointRegistry) remove(id string) (*dynamicEndpoint, bool) {
+	key, ok := parseEndpointID(id)
+	if !ok {
+		return nil, false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	endpoint, ok := r.endpoints[key]
+	if !ok {
+		return nil, false
+	}
+	endpoints := maps.Clone(r.endpoints)
+	delete(endpoints, key)
+	// removing endpoints can't introduce pattern conflicts
+	_ = r.rebuild(endpoints)
+	r.endpoints = endpoints
+	return endpoint, true
+}
+
+// update replaces the configuration of the endpoint with the given ID with
+// the result of modify. The endpoint keeps its position in the
+// configuration order, its creation time and its hit count. If method, URL,
+// match type or request predicates change, so does the ID.
+func (r *endpointRegistry) update(id string, modify func(spec configureReflectionSpec) (*configureReflectionSpec, error)) (*dynamicEndpoint, error) {
+	key, ok := parseEndpointID(id)
+	if !ok {
+		return nil, errEndpointNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	current, ok := r.endpoints[key]
+	if !ok {
+		return nil, errEndpointNotFound
+	}
+	spec, err := modify(current.spec())
+	if err != nil {
+		return nil, err
+	}
+	if len(spec.Endpoints) != 1 {
+		return nil, fieldErrorf("endpoints", "the configuration of an endpoint must contain exactly one endpoint")
+	}
+	if err = validateConfiguration(spec); err != nil {
+		return nil, err
+	}
+
+	endpoints := maps.Clone(r.endpoints)
+	delete(endpoints, key)
+	newKey := r.endpointKey(&spec.Endpoints[0])
+	if _, ok := endpoints[newKey]; ok {
+		return nil, errEndpointConflict
+	}
+	r.add(endpoints, spec, current.order-1)
+	updated := endpoints[newKey]
+	updated.created = current.created
+	if !updated.expires.IsZero() {
+		// the lifetime counts from the creation of the endpoint
+		expiresAfter, _ := parseExpiresAfter(updated.expiresAfter)
+		updated.expires = updated.created.Add(expiresAfter)
+	}
+	current.mutex.Lock()
+	updated.hits = current.hits
+	current.mutex.Unlock()
+	if err = r.rebuild(endpoints); err != nil {
+		return nil, err
+	}
+	r.endpoints = endpoints
+	return updated, nil
+}
+
+// evict removes all endpoints that expired or have been used up and returns
+// them.
+func (r *endpointRegistry) evict(now time.Time) []*dynamicEndpoint {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	evicted := []*dynamicEndpoint{}
+	endpoints := maps.Clone(r.endpoints)
+	for key, endpoint := range r.endpoints {
+		if !endpoint.available(now) {
+			evicted = append(evicted, endpoint)
+			delete(endpoints, key)
+		}
+	}
+	if len(evicted) > 0 {
+		// removing endpoints can't introduce pattern conflicts
+		_ = r.rebuild(endpoints)
+		r.endpoints = endpoints
+	}
+	return evicted
+}
+
+func parseExpiresAfter(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	expiresAfter, err := time.ParseDuration(value)
+	if err != nil || expiresAfter <= 0 {
+		return 0, fieldErrorf("expiresAfter", "invalid expiresAfter '%s'", value)
+	}
+	return expiresAfter, nil
+}
+
+func (r *endpointRegistry) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	clear(r.endpoints)
+	_ = r.rebuild(r.endpoints)
+}
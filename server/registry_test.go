@@ -0,0 +1,298 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type registryTestSuite struct {
+	serverSuite
+}
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(registryTestSuite))
+}
+
+func (s *registryTestSuite) SetupTest() {
+	s.serve()
+}
+
+func (s *registryTestSuite) configure(spec *configureReflectionSpec) *http.Response {
+	response, _ := s.doJSON("POST", "/configure_reflection", spec)
+	return response
+}
+
+func (s *registryTestSuite) statuses(path string) []int {
+	statuses := []int{}
+	for range 5 {
+		statuses = append(statuses, s.status("GET", path))
+	}
+	return statuses
+}
+
+func (s *registryTestSuite) endpoints() []endpointStatus {
+	response, body := s.do("GET", "/endpoints", "")
+	s.Require().Equal(http.StatusOK, response.StatusCode)
+	s.Equal("application/json", response.Header.Get("Content-Type"))
+	spec := &endpointsSpec{}
+	s.Require().NoError(json.Unmarshal([]byte(body), spec))
+	return spec.Endpoints
+}
+
+func (s *registryTestSuite) sequence(afterLast string, path string) *configureReflectionSpec {
+	return &configureReflectionSpec{
+		Responses: []reflectionSpec{
+			{Status: 401},
+			{Status: 200, Body: "welcome"},
+			{Status: 503},
+		},
+		AfterLast: afterLast,
+		Endpoints: []dynamicEndpointSpec{{Method: "GET", Url: path}},
+	}
+}
+
+func (s *registryTestSuite) TestSequence_Last() {
+	response := s.configure(s.sequence("", "/login"))
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal([]int{401, 200, 503, 503, 503}, s.statuses("/login"))
+}
+
+func (s *registryTestSuite) TestSequence_Loop() {
+	s.configure(s.sequence("loop", "/login"))
+	s.Equal([]int{401, 200, 503, 401, 200}, s.statuses("/login"))
+}
+
+func (s *registryTestSuite) TestSequence_Default() {
+	s.configure(s.sequence("default", "/login"))
+	s.Equal([]int{401, 200, 503, 200, 200}, s.statuses("/login"))
+
+	_, body := s.do("GET", "/login", "")
+	s.Empty(body)
+}
+
+func (s *registryTestSuite) TestSequence_Body() {
+	s.configure(s.sequence("", "/fresh"))
+	s.do("GET", "/fresh", "")
+	_, body := s.do("GET", "/fresh", "")
+	s.Equal("welcome", body)
+}
+
+func (s *registryTestSuite) TestSequence_InvalidAfterLast() {
+	response := s.configure(s.sequence("sometimes", "/login"))
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
+
+func (s *registryTestSuite) TestEndpoints_Hits() {
+	s.configure(s.sequence("loop", "/login"))
+	s.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 204},
+		Endpoints: []dynamicEndpointSpec{
+			{Method: "GET", Url: "/a"},
+			{Method: "POST", Url: "/a"},
+		},
+	})
+	s.statuses("/login")
+	s.do("GET", "/a", "")
+
+	endpoints := s.endpoints()
+	for i := range endpoints {
+		s.Len(endpoints[i].ID, 16)
+		s.False(endpoints[i].Created.IsZero())
+		endpoints[i].ID = ""
+		endpoints[i].Created = time.Time{}
+	}
+	s.Equal([]endpointStatus{
+		{Method: "GET", Url: "/a", Hits: 1},
+		{Method: "POST", Url: "/a", Hits: 0},
+		{Method: "GET", Url: "/login", Hits: 5, Responses: 3, AfterLast: "loop"},
+	}, endpoints)
+}
+
+func (s *registryTestSuite) TestReconfigureResetsHits() {
+	s.configure(s.sequence("", "/login"))
+	s.Equal([]int{401, 200, 503, 503, 503}, s.statuses("/login"))
+	s.configure(s.sequence("", "/login"))
+	s.Equal([]int{401, 200, 503, 503, 503}, s.statuses("/login"))
+}
+
+func (s *registryTestSuite) configureStatus(status int, endpoints ...dynamicEndpointSpec) {
+	response := s.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: status},
+		Endpoints:      endpoints,
+	})
+	s.Require().Equal(http.StatusOK, response.StatusCode)
+}
+
+func (s *registryTestSuite) status(method string, path string) int {
+	response, _ := s.do(method, path, "")
+	return response.StatusCode
+}
+
+func (s *registryTestSuite) TestMatch_AnyMethod() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "any", Url: "/exact?a=b"})
+	s.Equal(201, s.status("GET", "/exact?a=b"))
+	s.Equal(201, s.status("DELETE", "/exact?a=b"))
+	s.Equal(200, s.status("GET", "/exact?b=a"))
+}
+
+func (s *registryTestSuite) TestMatch_Prefix() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "GET", Url: "/api/", Match: "prefix"})
+	s.configureStatus(202, dynamicEndpointSpec{Method: "GET", Url: "/api/users", Match: "prefix"})
+	s.Equal(201, s.status("GET", "/api/items?x=y"))
+	s.Equal(202, s.status("GET", "/api/users/"))
+	s.Equal(202, s.status("GET", "/api/users?b=a&a=b"))
+	s.Equal(200, s.status("POST", "/api/users"))
+	s.Equal(200, s.status("GET", "/other"))
+}
+
+func (s *registryTestSuite) TestMatch_Glob() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "any", Url: "/files/*.txt", Match: "glob"})
+	s.Equal(201, s.status("GET", "/files/a.txt"))
+	s.Equal(201, s.status("GET", "/files/a.txt?download=1"))
+	s.Equal(200, s.status("GET", "/files/sub/a.txt"))
+	s.Equal(200, s.status("GET", "/files/a.pdf"))
+}
+
+func (s *registryTestSuite) TestMatch_Regex() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "GET", Url: `^/search\?.*q=attack`, Match: "regex"})
+	s.Equal(201, s.status("GET", "/search?page=1&q=attack"))
+	s.Equal(200, s.status("GET", "/search?q=benign"))
+}
+
+func (s *registryTestSuite) TestMatch_Pattern() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "any", Url: "/users/{id}", Match: "pattern"})
+	s.configureStatus(202, dynamicEndpointSpec{Method: "DELETE", Url: "/users/{id}", Match: "pattern"})
+	s.configureStatus(203, dynamicEndpointSpec{Method: "GET", Url: "/users/admin", Match: "pattern"})
+	s.Equal(201, s.status("GET", "/users/42"))
+	s.Equal(201, s.status("GET", "/users/42?verbose=true"))
+	s.Equal(202, s.status("DELETE", "/users/42"))
+	s.Equal(203, s.status("GET", "/users/admin"))
+	s.Equal(200, s.status("GET", "/users/42/posts"))
+}
+
+func (s *registryTestSuite) TestMatch_PatternRedirects() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "GET", Url: "/files/", Match: "pattern"})
+	s.configureStatus(202, dynamicEndpointSpec{Method: "GET", Url: "/exact/{$}", Match: "pattern"})
+	s.Equal(201, s.status("GET", "/files/"))
+	s.Equal(201, s.status("GET", "/files/a.txt"))
+	s.Equal(202, s.status("GET", "/exact/"))
+	// ServeMux would redirect these requests, they don't match
+	s.Equal(200, s.status("GET", "/files"))
+	s.Equal(200, s.status("GET", "/exact"))
+}
+
+func (s *registryTestSuite) TestMatch_Priority() {
+	s.configureStatus(205, dynamicEndpointSpec{Method: "GET", Url: "^/a/b", Match: "regex"})
+	s.configureStatus(204, dynamicEndpointSpec{Method: "GET", Url: "/a/*", Match: "glob"})
+	s.configureStatus(203, dynamicEndpointSpec{Method: "any", Url: "/a/", Match: "prefix"})
+	s.configureStatus(202, dynamicEndpointSpec{Method: "GET", Url: "/a/", Match: "prefix"})
+	s.configureStatus(201, dynamicEndpointSpec{Method: "GET", Url: "/a/{x}", Match: "pattern"})
+	s.configureStatus(210, dynamicEndpointSpec{Method: "GET", Url: "/a/b"})
+
+	s.Equal(210, s.status("GET", "/a/b"))
+	s.Equal(201, s.status("GET", "/a/c"))
+	s.Equal(202, s.status("GET", "/a/c/d"))
+	s.Equal(203, s.status("POST", "/a/c/d"))
+}
+
+func (s *registryTestSuite) TestMatch_Replace() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "GET", Url: "/a/", Match: "prefix"})
+	s.configureStatus(202, dynamicEndpointSpec{Method: "GET", Url: "/a/", Match: "prefix"})
+	s.Equal(202, s.status("GET", "/a/b"))
+
+	s.Len(s.endpoints(), 1)
+}
+
+func (s *registryTestSuite) TestMatch_Invalid() {
+	for _, endpoint := range []dynamicEndpointSpec{
+		{Method: "GET", Url: "/a", Match: "fuzzy"},
+		{Method: "GET", Url: "(", Match: "regex"},
+		{Method: "GET", Url: "[", Match: "glob"},
+		{Method: "GET", Url: "/{", Match: "pattern"},
+	} {
+		response := s.configure(&configureReflectionSpec{Endpoints: []dynamicEndpointSpec{endpoint}})
+		s.Equal(http.StatusBadRequest, response.StatusCode, endpoint)
+	}
+}
+
+func (s *registryTestSuite) TestMatch_ConflictingPatterns() {
+	s.configureStatus(201, dynamicEndpointSpec{Method: "GET", Url: "/users/{id}", Match: "pattern"})
+	response := s.configure(&configureReflectionSpec{
+		Endpoints: []dynamicEndpointSpec{{Method: "GET", Url: "/users/{name}", Match: "pattern"}},
+	})
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	// the existing configuration is retained
+	s.Equal(201, s.status("GET", "/users/42"))
+}
+
+func (s *registryTestSuite) TestPredicates_FirstMatchWins() {
+	s.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 200, Body: "leak: root:x:0:0"},
+		Endpoints: []dynamicEndpointSpec{{
+			Method:  "GET",
+			Url:     "/page",
+			Headers: []valueMatcherSpec{{Name: "X-Ftw-Marker", valueConditions: valueConditions{Equals: "leak"}}},
+		}},
+	})
+	s.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 200, Body: "harmless"},
+		Endpoints:      []dynamicEndpointSpec{{Method: "GET", Url: "/page"}},
+	})
+
+	body := func(marker string) string {
+		header := http.Header{}
+		if marker != "" {
+			header.Add("X-Ftw-Marker", marker)
+		}
+		_, content := doRequest(s.T(), http.DefaultClient, "GET", s.server.URL+"/page", "", header)
+		return content
+	}
+	s.Equal("leak: root:x:0:0", body("leak"))
+	s.Equal("harmless", body("other"))
+	s.Equal("harmless", body(""))
+
+	// the endpoints only differ in their predicates, so both are retained
+	s.Len(s.endpoints(), 2)
+}
+
+func (s *registryTestSuite) TestPredicates_BodyAndPattern() {
+	s.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 201},
+		Endpoints: []dynamicEndpointSpec{{
+			Method: "POST",
+			Url:    "/api/{resource}",
+			Match:  "pattern",
+			Body:   &bodyMatcherSpec{valueConditions: valueConditions{Regex: "union\\s+select"}},
+		}},
+	})
+	s.configure(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Status: 202},
+		Endpoints: []dynamicEndpointSpec{{
+			Method: "POST",
+			Url:    "/api/",
+			Match:  "pattern",
+		}},
+	})
+
+	post := func(body string) int {
+		response, _ := s.do("POST", "/api/users", body)
+		return response.StatusCode
+	}
+	s.Equal(201, post("1 union  select password"))
+	s.Equal(202, post("benign"))
+}
+
+func (s *registryTestSuite) TestPredicates_Invalid() {
+	response := s.configure(&configureReflectionSpec{
+		Endpoints: []dynamicEndpointSpec{{
+			Method:  "GET",
+			Url:     "/page",
+			Headers: []valueMatcherSpec{{Name: "X", valueConditions: valueConditions{Regex: "("}}},
+		}},
+	})
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeConfig describes the listeners started by Serve.
+type ServeConfig struct {
+	// Binding is the address to bind to.
+	Binding string
+	// Port is the port of the main listener. The main listener serves HTTPS
+	// if TLS is set and TLSPort is 0, plain HTTP otherwise.
+	Port int
+	// TLS enables HTTPS.
+	TLS *TLSConfig
+	// TLSPort is the port of an additional HTTPS listener, so that plain
+	// HTTP and HTTPS can be served side by side. Requires TLS.
+	TLSPort int
+	// H2C enables cleartext HTTP/2 (prior knowledge and upgrade) on the plain
+	// HTTP listener. HTTPS listeners always negotiate HTTP/2 through ALPN.
+	H2C bool
+	// ShutdownTimeout limits the time to wait for in-flight requests to
+	// complete during a graceful shutdown. 0 waits indefinitely.
+	ShutdownTimeout time.Duration
+	// AdminPort is the port of a separate listener for the built-in
+	// endpoints. If set, the other listeners treat every path as ordinary
+	// traffic. The admin listener serves HTTPS if TLS is set.
+	AdminPort int
+	// AdminBinding is the address the admin listener binds to. Defaults to
+	// Binding.
+	AdminBinding string
+}
+
+type shutdownContextKey struct{}
+
+// shuttingDown returns a channel that is closed once Serve starts to shut
+// down the server of the request. Requests that aren't served by Serve get a
+// nil channel, which is never ready.
+func shuttingDown(r *http.Request) <-chan struct{} {
+	shutdown, _ := r.Context().Value(shutdownContextKey{}).(chan struct{})
+	return shutdown
+}
+
+// Serve starts the listeners described by config and blocks until ctx is
+// canceled or one of the listeners fails. When ctx is canceled, the listeners
+// are shut down gracefully and Serve returns nil once in-flight requests have
+// completed. When a listener fails, all remaining listeners are closed
+// immediately and the error is returned.
+func (a *Albedo) Serve(ctx context.Context, config *ServeConfig) error {
+	if config.TLSPort != 0 && config.TLS == nil {
+		return errors.New("a TLS port requires a TLS configuration")
+	}
+	if config.AdminBinding != "" && config.AdminPort == 0 {
+		return errors.New("an admin binding requires an admin port")
+	}
+	if a.controlPrefixErr != nil {
+		return a.controlPrefixErr
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLS != nil {
+		var err error
+		tlsConfig, err = a.newTLSConfig(config.TLS)
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.endpointsFile != nil {
+		if err := a.loadEndpointsFile(); err != nil {
+			return err
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go a.watchEndpointsFile(watchCtx)
+	}
+	janitorCtx, cancelJanitor := context.WithCancel(ctx)
+	defer cancelJanitor()
+	go a.runJanitor(janitorCtx)
+
+	shutdown := make(chan struct{})
+	baseContext := func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), shutdownContextKey{}, shutdown)
+	}
+	dataHandler := a.Handler
+	if config.AdminPort != 0 {
+		dataHandler = a.DataHandler
+	}
+	servers := []*http.Server{}
+	if config.TLS == nil || config.TLSPort != 0 {
+		handler := dataHandler()
+		if config.H2C {
+			handler = withH2C(handler)
+		}
+		servers = append(servers, &http.Server{
+			Addr:        net.JoinHostPort(config.Binding, strconv.Itoa(config.Port)),
+			Handler:     handler,
+			BaseContext: baseContext,
+			ConnContext: a.connContext,
+		})
+	}
+	if tlsConfig != nil {
+		port := config.Port
+		if config.TLSPort != 0 {
+			port = config.TLSPort
+		}
+		servers = append(servers, &http.Server{
+			Addr:        net.JoinHostPort(config.Binding, strconv.Itoa(port)),
+			Handler:     dataHandler(),
+			TLSConfig:   tlsConfig,
+			BaseContext: baseContext,
+			ConnContext: a.connContext,
+		})
+	}
+	if config.AdminPort != 0 {
+		binding := config.AdminBinding
+		if binding == "" {
+			binding = config.Binding
+		}
+		servers = append(servers, &http.Server{
+			Addr:        net.JoinHostPort(binding, strconv.Itoa(config.AdminPort)),
+			Handler:     a.ControlHandler(),
+			TLSConfig:   tlsConfig,
+			BaseContext: baseContext,
+			ConnContext: a.connContext,
+		})
+	}
+
+	listeners := []net.Listener{}
+	for _, server := range servers {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		go func() {
+			if server.TLSConfig != nil {
+				a.log().Debug("Starting HTTPS server", "address", server.Addr)
+				// certificates are provided through TLSConfig
+				errs <- server.ServeTLS(a.countConnections(listeners[i]), "", "")
+			} else {
+				a.log().Debug("Starting HTTP server", "address", server.Addr)
+				errs <- server.Serve(&wireListener{a.countConnections(listeners[i])})
+			}
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		for _, server := range servers {
+			if closeErr := server.Close(); closeErr != nil {
+				a.log().Warn("Failed to close server", "address", server.Addr, "error", closeErr.Error())
+			}
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	a.log().Info("Shutting down server", "timeout", config.ShutdownTimeout)
+	close(shutdown)
+	shutdownCtx := context.Background()
+	if config.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, config.ShutdownTimeout)
+		defer cancel()
+	}
+	var shutdownErr error
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			a.log().Warn("Failed to shut down server gracefully", "address", server.Addr, "error", err.Error())
+			shutdownErr = errors.Join(shutdownErr, err, server.Close())
+		}
+	}
+	return shutdownErr
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type serveTestSuite struct {
+	suite.Suite
+}
+
+func TestServeTestSuite(t *testing.T) {
+	suite.Run(t, new(serveTestSuite))
+}
+
+func (s *serveTestSuite) TestServe_PortInUse() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { listener.Close() })
+
+	err = New().Serve(context.Background(), &ServeConfig{
+		Binding: "127.0.0.1",
+		Port:    listener.Addr().(*net.TCPAddr).Port,
+	})
+	s.Error(err)
+}
+
+func (s *serveTestSuite) TestServe_GracefulShutdown() {
+	port := freePort(s.T())
+	logOutput := &syncBuffer{}
+	albedo := New(WithLogger(slog.New(slog.NewTextHandler(logOutput, nil))))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- albedo.Serve(ctx, &ServeConfig{
+			Binding:         "127.0.0.1",
+			Port:            port,
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+	url := fmt.Sprintf("http://127.0.0.1:%d/inspect", port)
+	waitForServer(s.T(), fmt.Sprintf("http://127.0.0.1:%d/", port))
+
+	// start a request whose body is only completed after shutdown has begun
+	bodyReader, bodyWriter := io.Pipe()
+	inFlightClient := http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := inFlightClient.Post(url, "text/plain", bodyReader)
+		if err == nil {
+			responses <- response
+		}
+		close(responses)
+	}()
+	_, err := bodyWriter.Write([]byte("in-flight"))
+	s.Require().NoError(err)
+	s.Require().Eventually(func() bool {
+		return strings.Contains(logOutput.String(), "Received inspection request")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		s.Failf("server stopped before in-flight request completed", "error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.Require().NoError(bodyWriter.Close())
+	response, ok := <-responses
+	s.Require().True(ok)
+	s.Equal(http.StatusOK, response.StatusCode)
+
+	select {
+	case err := <-done:
+		s.NoError(err)
+	case <-time.After(5 * time.Second):
+		s.Fail("server did not shut down")
+	}
+}
+
+func (s *serveTestSuite) TestServe_ShutdownClosesHangingRequests() {
+	port := freePort(s.T())
+	logOutput := &syncBuffer{}
+	albedo := New(WithLogger(slog.New(slog.NewTextHandler(logOutput, nil))))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- albedo.Serve(ctx, &ServeConfig{
+			Binding:         "127.0.0.1",
+			Port:            port,
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+	waitForServer(s.T(), fmt.Sprintf("http://127.0.0.1:%d/", port))
+
+	errs := make(chan error, 1)
+	go func() {
+		response, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/reflect", port), "application/json",
+			strings.NewReader(`{"fault": {"mode": "hang"}}`))
+		if err == nil {
+			response.Body.Close()
+		}
+		errs <- err
+	}()
+	s.Require().Eventually(func() bool {
+		return strings.Contains(logOutput.String(), "Injecting fault: hanging")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		s.NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("hanging request held up the shutdown")
+	}
+	s.Error(<-errs)
+}
+
+func (s *serveTestSuite) TestServe_ShutdownEndsJournalWait() {
+	port := freePort(s.T())
+	logOutput := &syncBuffer{}
+	albedo := New(WithLogger(slog.New(slog.NewTextHandler(logOutput, nil))))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- albedo.Serve(ctx, &ServeConfig{
+			Binding:         "127.0.0.1",
+			Port:            port,
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+	waitForServer(s.T(), fmt.Sprintf("http://127.0.0.1:%d/", port))
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/journal/wait?path=/never&timeout=5m", port))
+		if err == nil {
+			response.Body.Close()
+			responses <- response
+		}
+		close(responses)
+	}()
+	s.Require().Eventually(func() bool {
+		return strings.Contains(logOutput.String(), "Received journal wait request")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		s.NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("journal wait held up the shutdown")
+	}
+	response, ok := <-responses
+	s.Require().True(ok)
+	s.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	s.Equal(errorCodeShuttingDown, response.Header.Get(errorHeader))
+}
+
+func (s *serveTestSuite) TestServe_ShutdownTimeout() {
+	port := freePort(s.T())
+	logOutput := &syncBuffer{}
+	albedo := New(WithLogger(slog.New(slog.NewTextHandler(logOutput, nil))))
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- albedo.Serve(ctx, &ServeConfig{
+			Binding:         "127.0.0.1",
+			Port:            port,
+			ShutdownTimeout: 50 * time.Millisecond,
+		})
+	}()
+	url := fmt.Sprintf("http://127.0.0.1:%d/inspect", port)
+	waitForServer(s.T(), fmt.Sprintf("http://127.0.0.1:%d/", port))
+
+	// a request that never completes
+	bodyReader, bodyWriter := io.Pipe()
+	s.T().Cleanup(func() { bodyWriter.Close() })
+	inFlightClient := http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	go func() {
+		response, err := inFlightClient.Post(url, "text/plain", bodyReader)
+		if err == nil {
+			response.Body.Close()
+		}
+	}()
+	_, err := bodyWriter.Write([]byte("in-flight"))
+	s.Require().NoError(err)
+	s.Require().Eventually(func() bool {
+		return strings.Contains(logOutput.String(), "Received inspection request")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		s.ErrorIs(err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		s.Fail("server did not shut down")
+	}
+}
+
+func (s *serveTestSuite) TestServe_AdminPort() {
+	port := freePort(s.T())
+	adminPort := freePort(s.T())
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	go func() {
+		_ = New().Serve(ctx, &ServeConfig{
+			Binding:   "127.0.0.1",
+			Port:      port,
+			AdminPort: adminPort,
+		})
+	}()
+	dataURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+	adminURL := fmt.Sprintf("http://127.0.0.1:%d", adminPort)
+	waitForServer(s.T(), dataURL+"/")
+	waitForServer(s.T(), adminURL+"/")
+
+	// control endpoints are ordinary traffic on the data port
+	response, _ := doRequest(s.T(), http.DefaultClient, "POST", dataURL+"/reflect", `{"status": 503}`, nil)
+	s.Equal(http.StatusOK, response.StatusCode)
+	response, _ = doRequest(s.T(), http.DefaultClient, "POST", dataURL+"/configure_reflection",
+		`{"status": 201, "endpoints": [{"method": "PUT", "url": "/reset"}]}`, nil)
+	s.Equal(http.StatusOK, response.StatusCode)
+	_, body := doRequest(s.T(), http.DefaultClient, "GET", adminURL+"/endpoints", "", nil)
+	s.JSONEq(`{"endpoints": []}`, body)
+
+	// the admin port only serves control endpoints
+	response, _ = doRequest(s.T(), http.DefaultClient, "POST", adminURL+"/configure_reflection",
+		`{"status": 201, "endpoints": [{"method": "PUT", "url": "/reset"}]}`, nil)
+	s.Equal(http.StatusOK, response.StatusCode)
+	response, _ = doRequest(s.T(), http.DefaultClient, "PUT", dataURL+"/reset", "", nil)
+	s.Equal(201, response.StatusCode)
+	response, _ = doRequest(s.T(), http.DefaultClient, "GET", adminURL+"/anything", "", nil)
+	s.Equal(http.StatusNotFound, response.StatusCode)
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func waitForServer(t *testing.T, url string) {
+	client := http.Client{Timeout: time.Second}
+	require.Eventually(t, func() bool {
+		response, err := client.Get(url)
+		if err != nil {
+			return false
+		}
+		response.Body.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -5,113 +5,126 @@ import (
 	_ "embed"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"hash/maphash"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
-	"sync"
 
 	"gopkg.in/yaml.v3"
 )
 
-var capabilities *CapabilitiesSpec
-var dynamicEndpointMutex = sync.RWMutex{}
-var dynamicEndpoints = map[uint64]reflectionSpec{}
-var dynamicEndpointsHash = maphash.Hash{}
+// defaultAlbedo backs the package-level functions, which share a single
+// instance for backwards compatibility. Use New to create isolated instances.
+var defaultAlbedo = New()
 
 //go:embed capabilities.yaml
 var capabilitiesDescription []byte
 
+// Start serves the shared default instance on the given address.
 func Start(binding string, port int) {
-	server := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", binding, port),
-		Handler: Handler(),
-	}
-
-	slog.Debug("Starting server")
-	err := server.ListenAndServe()
-	slog.Info("Server stopped", "exit-status", err)
+	defaultAlbedo.Start(binding, port)
 }
 
+// Handler returns the handler of the shared default instance. Handlers
+// returned by this function share their dynamic endpoint configuration; use
+// New to obtain an isolated instance.
 func Handler() http.Handler {
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/", handleDefault)
-	mux.HandleFunc("/capabilities", handleCapabilities)
-	mux.HandleFunc("/capabilities/", handleCapabilities)
-	mux.HandleFunc("POST /reflect", handleReflect)
-	mux.HandleFunc("POST /reflect/", handleReflect)
-	mux.HandleFunc("POST /configure_reflection", handleConfigureReflection)
-	mux.HandleFunc("POST /configure_reflection/", handleConfigureReflection)
-	mux.HandleFunc("PUT /reset", handleReset)
-	mux.HandleFunc("PUT /reset/", handleReset)
-	mux.HandleFunc("/inspect", handleInspect)
-	mux.HandleFunc("/inspect/", handleInspect)
-
-	return mux
+	return defaultAlbedo.Handler()
+}
+
+// Start serves the instance on the given address until the listener fails.
+func (a *Albedo) Start(binding string, port int) {
+	err := a.Serve(context.Background(), &ServeConfig{Binding: binding, Port: port})
+	a.log().Info("Server stopped", "exit-status", err)
+}
+
+func handleDefault(w http.ResponseWriter, r *http.Request) {
+	defaultAlbedo.handleDefault(w, r)
+}
+
+func handleReflect(w http.ResponseWriter, r *http.Request) {
+	defaultAlbedo.handleReflect(w, r)
+}
+
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	defaultAlbedo.handleCapabilities(w, r)
+}
+
+func handleConfigureReflection(w http.ResponseWriter, r *http.Request) {
+	defaultAlbedo.handleConfigureReflection(w, r)
+}
+
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	defaultAlbedo.handleReset(w, r)
+}
+
+func handleInspect(w http.ResponseWriter, r *http.Request) {
+	defaultAlbedo.handleInspect(w, r)
+}
+
+func computeEndpointKey(method string, url string) uint64 {
+	return defaultAlbedo.global.endpoints.key(method, url)
 }
 
 // Respond with empty 200 for all requests by default.
 // If the request matches a configured dynamic endpoint, reflect as specified
 // for that endpoint.
-func handleDefault(w http.ResponseWriter, r *http.Request) {
-	key := computeEndpointKey(r.Method, r.RequestURI)
-	dynamicEndpointMutex.RLock()
-	reflectionSpec, ok := dynamicEndpoints[key]
-	dynamicEndpointMutex.RUnlock()
+func (a *Albedo) handleDefault(w http.ResponseWriter, r *http.Request) {
+	body, bodySize, err := readBodyPrefix(r.Body, max(a.global.journal.bodyLimit, maxPredicateBodySize, maxEchoBodySize))
+	if err != nil {
+		a.log().Warn("Failed to read request body", "error", err.Error())
+	}
+	dynamicEndpoint, ok := a.lookup(a.namespace(r), r, body)
 
-	if ok {
-		doReflect(w, r, &reflectionSpec)
+	if !ok {
+		a.recordRequest(r, body, bodySize, nil)
+		a.log().Info(fmt.Sprintf("Received default request to %s", r.URL))
+		return
+	}
+
+	a.recordRequest(r, body, bodySize, &dynamicEndpoint.endpoint)
+	setMetricsEndpoint(r, dynamicEndpoint.endpoint.Url)
+	if reflection := dynamicEndpoint.hit(); reflection != nil {
+		a.doReflect(w, r, body, bodySize, reflection)
 	} else {
-		slog.Info(fmt.Sprintf("Received default request to %s", r.URL))
+		a.log().Info(fmt.Sprintf("Endpoint exhausted, received default request to %s", r.URL))
 	}
 }
 
-func handleReflect(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Received reflection request")
+func (a *Albedo) handleReflect(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received reflection request")
 
-	slog.Debug("Reading body")
+	a.log().Debug("Reading body")
 	body, err := io.ReadAll(r.Body)
-	if slog.Default().Enabled(context.TODO(), slog.LevelDebug) {
+	a.recordRequest(r, body, int64(len(body)), nil)
+	if a.log().Enabled(context.TODO(), slog.LevelDebug) {
 		numBytes, unit := toHumanReadableMemorySize(uint64(len(body)))
-		slog.Debug(fmt.Sprintf("Body size: %d%s", numBytes, unit))
+		a.log().Debug(fmt.Sprintf("Body size: %d%s", numBytes, unit))
 	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write([]byte("Failed to parse request body"))
-		if err != nil {
-			slog.Warn("Failed to write response body", "error", err.Error())
-		}
-		slog.Warn("Failed to parse request body")
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidBody, fmt.Errorf("Failed to parse request body: %w", err))
 		return
 	}
-	slog.Debug("Parsing reflection specification")
+	a.log().Debug("Parsing reflection specification")
 	spec := &reflectionSpec{}
 	if err = json.Unmarshal(body, spec); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write([]byte("Invalid JSON in request body"))
-		if err != nil {
-			slog.Warn("Failed to write response body", "error", err.Error())
-		}
-		slog.Warn("Invalid JSON in request body")
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidJSON, fmt.Errorf("Invalid JSON in request body: %w", err))
 		return
 	}
 
-	doReflect(w, r, spec)
+	a.doReflect(w, r, body, int64(len(body)), spec)
 
 }
 
-func handleCapabilities(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Received capabilities request")
+func (a *Albedo) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received capabilities request")
 	w.Header().Add("Content-Type", "application/json")
 
-	spec := getCapabilities()
+	spec := a.getCapabilities()
 	if r.URL.Query().Get("quiet") == "true" {
 		quietSpec := &CapabilitiesSpec{}
 		for _, ep := range spec.Endpoints {
@@ -134,53 +147,91 @@ func handleCapabilities(w http.ResponseWriter, r *http.Request) {
 
 	_, err = w.Write(body)
 	if err != nil {
-		slog.Warn("Failed to write response body", "error", err.Error())
+		a.log().Warn("Failed to write response body", "error", err.Error())
 	}
 }
 
-func handleConfigureReflection(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Received configuration request")
+func (a *Albedo) handleConfigureReflection(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received configuration request")
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write([]byte("Failed to parse request body"))
-		if err != nil {
-			slog.Warn("Failed to write response body", "error", err.Error())
-		}
-		slog.Info("Failed to parse request body")
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidBody, fmt.Errorf("Failed to parse request body: %w", err))
 		return
 	}
 	spec := &configureReflectionSpec{}
 	if err = json.Unmarshal(body, spec); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write([]byte("Invalid JSON in request body"))
-		if err != nil {
-			slog.Warn("Failed to write response body", "error", err.Error())
-		}
-		slog.Info("Invalid JSON in request body")
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidJSON, fmt.Errorf("Invalid JSON in request body: %w", err))
+		return
+	}
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	err = validateConfiguration(spec)
+	if err == nil {
+		err = ns.endpoints.configure(spec)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, errorCodeInvalidSpecification, err)
+		return
+	}
+	a.metrics.countConfiguration()
+	a.persistEndpoints(ns)
+}
+
+func (a *Albedo) handleEndpoints(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received endpoints request")
+	w.Header().Add("Content-Type", "application/json")
+
+	ns, ok := a.controlNamespace(w, r)
+	if !ok {
+		return
+	}
+	spec := &endpointsSpec{Endpoints: ns.endpoints.list()}
+	var body []byte
+	var err error
+	if r.URL.Query().Get("pretty") == "true" {
+		body, err = json.MarshalIndent(spec, "", "  ")
+	} else {
+		body, err = json.Marshal(spec)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, errorCodeInternal, fmt.Errorf("Failed to marshal endpoints: %w", err))
 		return
 	}
 
-	dynamicEndpointMutex.Lock()
-	defer dynamicEndpointMutex.Unlock()
-	for _, _endpoint := range spec.Endpoints {
-		key := computeEndpointKey(_endpoint.Method, _endpoint.Url)
-		dynamicEndpoints[key] = spec.reflectionSpec
+	_, err = w.Write(body)
+	if err != nil {
+		a.log().Warn("Failed to write response body", "error", err.Error())
 	}
 }
 
-func handleReset(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Received reset request. Discarding all endpoint configurations now")
-	for k := range dynamicEndpoints {
-		delete(dynamicEndpoints, k)
+func (a *Albedo) handleReset(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received reset request. Discarding all endpoint configurations now")
+	a.metrics.countReset()
+	if name := namespaceName(r); name != "" {
+		a.log().Info(fmt.Sprintf("Discarding namespace '%s'", name))
+		a.removeNamespace(name)
+		return
 	}
+	a.global.endpoints.reset()
+	a.persistEndpoints(a.global)
 }
 
-func handleInspect(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Received inspection request")
+func (a *Albedo) handleInspect(w http.ResponseWriter, r *http.Request) {
+	a.log().Info("Received inspection request")
 
-	logAttrs := []any{slog.String("protocol", r.Proto)}
+	protocol := getProtocolInfo(r)
+	logAttrs := []any{slog.String("protocol", protocol.Proto)}
+	transportAttrs := []any{slog.Bool("tls", protocol.TLS), slog.Bool("h2c", protocol.H2C)}
+	if protocol.ALPN != "" {
+		transportAttrs = append(transportAttrs, slog.String("alpn", protocol.ALPN))
+	}
+	if protocol.Connection != 0 {
+		transportAttrs = append(transportAttrs, slog.Uint64("connection", protocol.Connection), slog.Uint64("sequence", protocol.Sequence))
+	}
+	logAttrs = append(logAttrs, slog.Group("transport", transportAttrs...))
 	logAttrs = append(logAttrs, slog.String("verb", r.Method))
 	reqURLElements := strings.Split(r.URL.String(), "/inspect")
 	logAttrs = append(logAttrs, slog.String("endpoint", fmt.Sprintf("/%s", strings.TrimPrefix(reqURLElements[len(reqURLElements)-1], "/"))))
@@ -198,29 +249,33 @@ func handleInspect(w http.ResponseWriter, r *http.Request) {
 	logAttrs = append(logAttrs, slog.Group("headers", headersAttrs...))
 
 	body, err := io.ReadAll(r.Body)
+	a.recordRequest(r, body, int64(len(body)), nil)
 	if err != nil {
-		slog.Warn("failed to read body", "error", err)
+		a.log().Warn("failed to read body", "error", err)
 	} else {
 		bodyAttrs := []any{}
 		numBytes, unit := toHumanReadableMemorySize(uint64(len(body)))
 		bodyLengthAttrs := []any{slog.Uint64("value", numBytes), slog.String("unit", unit)}
 		bodyAttrs = append(bodyAttrs, slog.Group("length", bodyLengthAttrs...))
 
-		if slog.Default().Enabled(context.TODO(), slog.LevelDebug) {
+		if a.log().Enabled(context.TODO(), slog.LevelDebug) {
 			bodyAttrs = append(bodyAttrs, slog.String("content", string(body)))
 		}
 		logAttrs = append(logAttrs, slog.Group("body", bodyAttrs...))
 	}
-	slog.Default().LogAttrs(context.TODO(), slog.LevelInfo, "Request information", slog.Group("request", logAttrs...))
+	a.log().LogAttrs(context.TODO(), slog.LevelInfo, "Request information", slog.Group("request", logAttrs...))
 }
 
-func decodeBody(spec *reflectionSpec) (string, error) {
-	slog.Debug("Decoding body")
+func (a *Albedo) decodeBody(spec *reflectionSpec) (string, error) {
+	a.log().Debug("Decoding body")
 
 	if spec.Body != "" {
 		return spec.Body, nil
 	}
+	return decodeEncodedBody(spec)
+}
 
+func decodeEncodedBody(spec *reflectionSpec) (string, error) {
 	if spec.EncodedBody == "" {
 		return "", nil
 	}
@@ -228,87 +283,122 @@ func decodeBody(spec *reflectionSpec) (string, error) {
 	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(spec.EncodedBody))
 	bodyBytes, err := io.ReadAll(decoder)
 	if err != nil {
-		return "", errors.New("invalid base64 encoding of response body")
+		return "", fieldErrorf("encodedBody", "invalid base64 encoding of response body")
 
 	}
 	return string(bodyBytes), nil
 }
 
-func computeEndpointKey(method string, url string) uint64 {
-	dynamicEndpointsHash.Reset()
-	dynamicEndpointsHash.WriteString(method)
-	dynamicEndpointsHash.WriteString(url)
-	return dynamicEndpointsHash.Sum64()
-}
-
-func doReflect(w http.ResponseWriter, r *http.Request, spec *reflectionSpec) {
-	slog.Info(fmt.Sprintf("Reflecting response for '%s' request to '%s'", r.Method, r.RequestURI))
+// doReflect responds as described by the specification. body holds the
+// (possibly partial) body of the request and bodySize the size of the
+// complete body.
+func (a *Albedo) doReflect(w http.ResponseWriter, r *http.Request, body []byte, bodySize int64, spec *reflectionSpec) {
+	a.log().Info(fmt.Sprintf("Reflecting response for '%s' request to '%s'", r.Method, r.RequestURI))
 
 	if spec.LogMessage != "" {
-		slog.Info(spec.LogMessage)
+		a.log().Info(spec.LogMessage)
+	}
+
+	if spec.RawResponse != "" {
+		a.metrics.countReflection()
+		a.doReflectRaw(w, r, spec)
+		return
+	}
+
+	if spec.Template {
+		var err error
+		if spec, err = a.renderTemplates(r, body, spec); err != nil {
+			a.writeReflectionError(w, err)
+			return
+		}
 	}
 
 	for name, value := range spec.Headers {
-		slog.Info(fmt.Sprintf("Reflecting header '%s':'%s'", name, value))
+		a.log().Info(fmt.Sprintf("Reflecting header '%s':'%s'", name, value))
 		w.Header().Add(name, value)
 	}
 
-	if spec.Status > 0 && spec.Status < 100 || spec.Status >= 600 {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err := fmt.Fprintf(w, "Invalid status code: %d", spec.Status)
+	if spec.Echo {
+		echo, contentType, err := renderEcho(r, body, bodySize, spec.EchoFormat, false)
 		if err != nil {
-			slog.Warn("Failed to write response body", "error", err.Error())
+			a.writeReflectionError(w, nestField("echoFormat", err))
+			return
 		}
-		slog.Info(fmt.Sprintf("Invalid status code: %d", spec.Status))
+		a.log().Info("Echoing request")
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		echoSpec := *spec
+		echoSpec.Body = string(echo)
+		echoSpec.EncodedBody = ""
+		echoSpec.Chunks = nil
+		echoSpec.EncodedChunks = nil
+		spec = &echoSpec
+	}
+
+	if spec.Status > 0 && spec.Status < 100 || spec.Status >= 600 {
+		a.writeReflectionError(w, fieldErrorf("status", "Invalid status code: %d", spec.Status))
 		return
 	}
 	status := spec.Status
 	if status == 0 {
 		status = http.StatusOK
 	}
-	slog.Info(fmt.Sprintf("Reflecting status '%d'", status))
-	w.WriteHeader(status)
 
-	responseBody, err := decodeBody(spec)
+	response, err := a.newReflection(spec, status)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write([]byte(err.Error()))
-		if err != nil {
-			slog.Warn("Failed to write response body", "error", err.Error())
-		}
-		slog.Info(err.Error())
+		a.writeReflectionError(w, err)
 		return
 	}
+	a.metrics.countReflection()
+	a.writeReflection(w, r, response)
+}
 
-	if responseBody == "" {
-		return
-	}
+// writeReflectionError responds with 400 when a reflection specification
+// can't be reflected. Headers added from the specification are discarded.
+func (a *Albedo) writeReflectionError(w http.ResponseWriter, err error) {
+	clear(w.Header())
+	a.writeError(w, http.StatusBadRequest, errorCodeInvalidSpecification, err)
+}
 
-	responseBodyBytes := []byte(responseBody)
-	if len(responseBody) > 200 {
-		responseBody = responseBody[:min(len(responseBody), 200)] + "..."
-	}
-	slog.Info(fmt.Sprintf("Reflecting body '%s'", responseBody))
-	_, err = w.Write(responseBodyBytes)
-	if err != nil {
-		slog.Warn("Failed to write response body", "error", err.Error())
-	}
+func (a *Albedo) getCapabilities() *CapabilitiesSpec {
+	a.capabilitiesOnce.Do(func() {
+		if a.capabilities == nil {
+			spec := &CapabilitiesSpec{}
+			err := yaml.Unmarshal(capabilitiesDescription, spec)
+			if err != nil {
+				a.log().Error("Failed to unmarshal capabilities description")
+			}
+			a.capabilities = spec
+		}
+		if a.controlPrefix != "" {
+			a.capabilities = prefixCapabilities(a.capabilities, a.controlPrefix)
+		}
+	})
+
+	return a.capabilities
 }
 
-func getCapabilities() *CapabilitiesSpec {
-	if capabilities != nil {
-		return capabilities
+// prefixCapabilities returns a copy of spec in which the paths of the
+// built-in endpoints, and references to them in the descriptions, start with
+// prefix.
+func prefixCapabilities(spec *CapabilitiesSpec, prefix string) *CapabilitiesSpec {
+	replacements := []string{}
+	for _, ep := range spec.Endpoints {
+		if ep.Path != "/*" {
+			replacements = append(replacements, `"`+ep.Path, `"`+prefix+ep.Path)
+		}
 	}
-
-	spec := &CapabilitiesSpec{}
-	err := yaml.Unmarshal(capabilitiesDescription, spec)
-	if err != nil {
-		slog.Error("Failed to unmarshal capabilities description")
+	replacer := strings.NewReplacer(replacements...)
+	prefixed := &CapabilitiesSpec{}
+	for _, ep := range spec.Endpoints {
+		if ep.Path != "/*" {
+			ep.Path = prefix + ep.Path
+		}
+		ep.Description = replacer.Replace(ep.Description)
+		prefixed.Endpoints = append(prefixed.Endpoints, ep)
 	}
-
-	capabilities = spec
-
-	return capabilities
+	return prefixed
 }
 
 func toHumanReadableMemorySize(numBytes uint64) (uint64, string) {
@@ -106,6 +106,16 @@ func (s *serverTestSuite) TestReflect_EncodedBody() {
 	s.Equal(responseBodyString, string(reflectedBody))
 }
 
+func (s *serverTestSuite) TestConfigure_InvalidEncodedBody() {
+	server := httptest.NewServer(New().Handler())
+	s.T().Cleanup(server.Close)
+
+	response, body := doRequest(s.T(), http.DefaultClient, http.MethodPost, server.URL+"/configure_reflection",
+		`{"encodedBody": "!", "endpoints": [{"method": "GET", "url": "/encoded"}]}`, nil)
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "invalid_specification", "message": "invalid base64 encoding of response body", "field": "encodedBody"}`, body)
+}
+
 func (s *serverTestSuite) TestReflect_LogMessage() {
 	logBuffer := &bytes.Buffer{}
 	log.SetOutput(logBuffer)
@@ -148,13 +158,18 @@ func (s *serverTestSuite) TestCapabilities() {
 	err = json.Unmarshal(body, spec)
 	s.Require().NoError(err)
 
-	s.Len(spec.Endpoints, 6)
+	s.Len(spec.Endpoints, 11)
 	s.Equal("/*", spec.Endpoints[0].Path)
 	s.Equal("/capabilities", spec.Endpoints[1].Path)
 	s.Equal("/reflect", spec.Endpoints[2].Path)
 	s.Equal("/configure_reflection", spec.Endpoints[3].Path)
 	s.Equal("/reset", spec.Endpoints[4].Path)
 	s.Equal("/inspect", spec.Endpoints[5].Path)
+	s.Equal("/journal", spec.Endpoints[6].Path)
+	s.Equal("/journal/wait", spec.Endpoints[7].Path)
+	s.Equal("/endpoints", spec.Endpoints[8].Path)
+	s.Equal("/echo", spec.Endpoints[9].Path)
+	s.Equal("/metrics", spec.Endpoints[10].Path)
 
 	for _, ep := range spec.Endpoints {
 		s.NotEmpty(ep.ContentType)
@@ -163,6 +178,26 @@ func (s *serverTestSuite) TestCapabilities() {
 	}
 }
 
+func (s *serverTestSuite) TestCapabilities_ControlPrefix() {
+	server := httptest.NewServer(New(WithControlPrefix("/_albedo", false)).Handler())
+	s.T().Cleanup(server.Close)
+
+	_, body := doRequest(s.T(), http.DefaultClient, "GET", server.URL+"/_albedo/capabilities", "", nil)
+	spec := &CapabilitiesSpec{}
+	s.Require().NoError(json.Unmarshal([]byte(body), spec))
+
+	s.Equal("/*", spec.Endpoints[0].Path)
+	s.Equal("/_albedo/capabilities", spec.Endpoints[1].Path)
+	s.Equal("/_albedo/reflect", spec.Endpoints[2].Path)
+	s.Equal("/_albedo/journal/wait", spec.Endpoints[7].Path)
+	s.Contains(spec.Endpoints[3].Description, `behave as if it were the "/_albedo/reflect" endpoint`)
+	s.Contains(spec.Endpoints[8].Description, `"/_albedo/endpoints/{id}"`)
+	s.NotContains(body, `"/reflect`)
+
+	_, body = doRequest(s.T(), http.DefaultClient, "GET", server.URL+"/capabilities", "", nil)
+	s.Empty(body)
+}
+
 func (s *serverTestSuite) TestCapabilities_Quiet() {
 	server := httptest.NewServer((http.HandlerFunc)(handleCapabilities))
 	s.T().Cleanup(server.Close)
@@ -184,13 +219,18 @@ func (s *serverTestSuite) TestCapabilities_Quiet() {
 	err = json.Unmarshal(body, spec)
 	s.Require().NoError(err)
 
-	s.Len(spec.Endpoints, 6)
+	s.Len(spec.Endpoints, 11)
 	s.Equal("/*", spec.Endpoints[0].Path)
 	s.Equal("/capabilities", spec.Endpoints[1].Path)
 	s.Equal("/reflect", spec.Endpoints[2].Path)
 	s.Equal("/configure_reflection", spec.Endpoints[3].Path)
 	s.Equal("/reset", spec.Endpoints[4].Path)
 	s.Equal("/inspect", spec.Endpoints[5].Path)
+	s.Equal("/journal", spec.Endpoints[6].Path)
+	s.Equal("/journal/wait", spec.Endpoints[7].Path)
+	s.Equal("/endpoints", spec.Endpoints[8].Path)
+	s.Equal("/echo", spec.Endpoints[9].Path)
+	s.Equal("/metrics", spec.Endpoints[10].Path)
 }
 
 func (s *serverTestSuite) TestCapabilities_Pretty() {
@@ -226,7 +266,7 @@ func (s *serverTestSuite) TestConfigureReflection() {
 	responseBodyString := "a dummy body \t \n\r\r\n\r\n"
 	responseBody := base64.StdEncoding.EncodeToString([]byte(responseBodyString))
 	spec := &configureReflectionSpec{
-		reflectionSpec{
+		reflectionSpec: reflectionSpec{
 			Status: 202,
 			Headers: map[string]string{
 				"header1":  "value 1",
@@ -234,7 +274,7 @@ func (s *serverTestSuite) TestConfigureReflection() {
 			},
 			EncodedBody: responseBody,
 		},
-		[]dynamicEndpointSpec{
+		Endpoints: []dynamicEndpointSpec{
 			{
 				Method: "GET",
 				Url:    "/foo/bar",
@@ -285,7 +325,7 @@ func (s *serverTestSuite) TestConfigureReflection_SameUrl() {
 	responseBodyString := "a dummy body \t \n\r\r\n\r\n"
 	responseBody := base64.StdEncoding.EncodeToString([]byte(responseBodyString))
 	spec := &configureReflectionSpec{
-		reflectionSpec{
+		reflectionSpec: reflectionSpec{
 			Status: 202,
 			Headers: map[string]string{
 				"header1":  "value 1",
@@ -293,7 +333,7 @@ func (s *serverTestSuite) TestConfigureReflection_SameUrl() {
 			},
 			EncodedBody: responseBody,
 		},
-		[]dynamicEndpointSpec{
+		Endpoints: []dynamicEndpointSpec{
 			{
 				Method: "GET",
 				Url:    "/foo/bar",
@@ -344,12 +384,12 @@ func (s *serverTestSuite) TestReset() {
 	s.T().Cleanup(server.Close)
 
 	spec := &configureReflectionSpec{
-		reflectionSpec{
+		reflectionSpec: reflectionSpec{
 			Status:      234,
 			Headers:     map[string]string{},
 			EncodedBody: "",
 		},
-		[]dynamicEndpointSpec{
+		Endpoints: []dynamicEndpointSpec{
 			{
 				Method: "GET",
 				Url:    "/foo/bar",
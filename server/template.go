@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+const randomStringAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// templateData is the data available to response templates.
+type templateData struct {
+	Method     string
+	URI        string
+	Path       string
+	Query      url.Values
+	Host       string
+	Headers    http.Header
+	Body       string
+	RemoteAddr string
+	Timestamp  time.Time
+	// Counter is incremented for every templated response of the instance,
+	// starting at 1.
+	Counter uint64
+}
+
+var templateFuncs = template.FuncMap{
+	"randomInt":    randomInt,
+	"randomString": randomString,
+	"uuid":         randomUUID,
+	"base64":       func(value string) string { return base64.StdEncoding.EncodeToString([]byte(value)) },
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	parsed, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template for %s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// validateTemplates checks that the templates of the specification parse.
+func validateTemplates(spec *reflectionSpec) error {
+	if !spec.Template {
+		return nil
+	}
+	if _, err := parseTemplate("body", spec.Body); err != nil {
+		return nestField("body", err)
+	}
+	for name, value := range spec.Headers {
+		if _, err := parseTemplate("header "+name, value); err != nil {
+			return nestField("headers."+name, err)
+		}
+	}
+	return nil
+}
+
+// renderTemplates returns a copy of the specification with body and header
+// values rendered as templates. body holds the (possibly partial) body of the
+// request.
+func (a *Albedo) renderTemplates(r *http.Request, body []byte, spec *reflectionSpec) (*reflectionSpec, error) {
+	data := &templateData{
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Host:       r.Host,
+		Headers:    r.Header,
+		Body:       string(body),
+		RemoteAddr: r.RemoteAddr,
+		Timestamp:  time.Now(),
+		Counter:    a.templateCounter.Add(1),
+	}
+	render := func(name string, text string) (string, error) {
+		parsed, err := parseTemplate(name, text)
+		if err != nil {
+			return "", err
+		}
+		var buffer bytes.Buffer
+		if err := parsed.Execute(&buffer, data); err != nil {
+			return "", fmt.Errorf("failed to render template for %s: %w", name, err)
+		}
+		return buffer.String(), nil
+	}
+
+	rendered := *spec
+	var err error
+	if rendered.Body, err = render("body", spec.Body); err != nil {
+		return nil, nestField("body", err)
+	}
+	rendered.Headers = make(map[string]string, len(spec.Headers))
+	for name, value := range spec.Headers {
+		if rendered.Headers[name], err = render("header "+name, value); err != nil {
+			return nil, nestField("headers."+name, err)
+		}
+	}
+	return &rendered, nil
+}
+
+// randomInt returns a random integer in [low, high].
+func randomInt(low int, high int) (int, error) {
+	if high < low {
+		return 0, fmt.Errorf("randomInt: %d is less than %d", high, low)
+	}
+	return low + rand.IntN(high-low+1), nil
+}
+
+// randomString returns a random alphanumeric string of the given length.
+func randomString(length int) string {
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = randomStringAlphabet[rand.IntN(len(randomStringAlphabet))]
+	}
+	return string(result)
+}
+
+// randomUUID returns a random (version 4) UUID.
+func randomUUID() string {
+	var uuid [16]byte
+	// never returns an error, see crypto/rand.Read
+	_, _ = cryptorand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type templateTestSuite struct {
+	serverSuite
+}
+
+func TestTemplateTestSuite(t *testing.T) {
+	suite.Run(t, new(templateTestSuite))
+}
+
+func (s *templateTestSuite) SetupTest() {
+	s.serve()
+}
+
+func (s *templateTestSuite) configure(spec *reflectionSpec) {
+	configuration := &configureReflectionSpec{
+		reflectionSpec: *spec,
+		Endpoints:      []dynamicEndpointSpec{{Method: methodAny, Url: "/search", Match: matchPrefix}},
+	}
+	s.Require().NoError(validateConfiguration(configuration))
+	s.Require().NoError(s.albedo.global.endpoints.configure(configuration))
+}
+
+func (s *templateTestSuite) TestRequestData() {
+	s.configure(&reflectionSpec{
+		Template: true,
+		Headers:  map[string]string{"X-Method": "{{ .Method }}", "X-Agent": `{{ .Headers.Get "User-Agent" }}`},
+		Body:     `<html><body>Results for {{ .Query.Get "q" }} at {{ .Path }} ({{ .URI }}): {{ .Body }}</body></html>`,
+	})
+
+	response, body := doRequest(s.T(), http.DefaultClient, http.MethodPost, s.server.URL+"/search/all?q=<script>alert(1)</script>", "payload",
+		http.Header{"User-Agent": {"test-agent"}})
+
+	s.Equal("POST", response.Header.Get("X-Method"))
+	s.Equal("test-agent", response.Header.Get("X-Agent"))
+	s.Equal("<html><body>Results for <script>alert(1)</script> at /search/all (/search/all?q=<script>alert(1)</script>): payload</body></html>", body)
+}
+
+func (s *templateTestSuite) TestHelpers() {
+	s.configure(&reflectionSpec{
+		Template: true,
+		Body:     `{{ .Counter }} {{ randomInt 5 5 }} {{ len (randomString 12) }} {{ uuid }} {{ base64 "a" }} {{ json .Method }} {{ .Timestamp.Year }}`,
+	})
+
+	_, first := s.do("GET", "/search", "")
+	_, second := s.do("GET", "/search", "")
+
+	s.Regexp(regexp.MustCompile(`^1 5 12 [0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12} YQ== "GET" \d{4}$`), first)
+	s.True(strings.HasPrefix(second, "2 "), second)
+	s.NotEqual(strings.Fields(first)[3], strings.Fields(second)[3])
+}
+
+func (s *templateTestSuite) TestNotTemplated() {
+	s.configure(&reflectionSpec{Body: "{{ .Method }}"})
+
+	_, body := s.do("GET", "/search", "")
+	s.Equal("{{ .Method }}", body)
+}
+
+func (s *templateTestSuite) TestInvalidTemplate() {
+	err := validateConfiguration(&configureReflectionSpec{
+		reflectionSpec: reflectionSpec{Template: true, Body: "{{ .Method "},
+	})
+	s.ErrorContains(err, "invalid template for body")
+
+	err = validateConfiguration(&configureReflectionSpec{
+		Responses: []reflectionSpec{{}, {Template: true, Headers: map[string]string{"X-A": "{{ nope }}"}}},
+	})
+	s.ErrorContains(err, "response 1: invalid template for header X-A")
+
+	response, _ := s.doJSON("POST", "/reflect", &reflectionSpec{Template: true, Body: `{{ randomInt 2 1 }}`})
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+}
+
+func (s *templateTestSuite) TestRandomHelpers() {
+	for range 100 {
+		value, err := randomInt(-2, 2)
+		s.Require().NoError(err)
+		s.GreaterOrEqual(value, -2)
+		s.LessOrEqual(value, 2)
+	}
+	_, err := randomInt(1, 0)
+	s.Error(err)
+	s.Regexp(`^[a-zA-Z0-9]{7}$`, randomString(7))
+	s.Regexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, randomUUID())
+}
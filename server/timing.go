@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// responseTiming controls when and how fast a reflected response is written.
+type responseTiming struct {
+	headerDelay time.Duration
+	bodyDelay   time.Duration
+	// if chunkInterval is set, the body is written in chunks of chunkSize
+	// bytes with chunkInterval between them
+	chunkSize     int
+	chunkInterval time.Duration
+}
+
+func parseResponseTiming(spec *reflectionSpec) (*responseTiming, error) {
+	timing := &responseTiming{chunkSize: spec.ChunkSize}
+	var err error
+	if timing.headerDelay, err = parseDelay("headerDelay", spec.HeaderDelay); err != nil {
+		return nil, err
+	}
+	if timing.bodyDelay, err = parseDelay("bodyDelay", spec.BodyDelay); err != nil {
+		return nil, err
+	}
+	if timing.chunkInterval, err = parseDelay("chunkInterval", spec.ChunkInterval); err != nil {
+		return nil, err
+	}
+	if spec.BytesPerSecond < 0 {
+		return nil, fieldErrorf("bytesPerSecond", "invalid bytesPerSecond: %d", spec.BytesPerSecond)
+	}
+	if spec.ChunkSize < 0 {
+		return nil, fieldErrorf("chunkSize", "invalid chunkSize: %d", spec.ChunkSize)
+	}
+
+	if spec.BytesPerSecond > 0 {
+		// write ten chunks per second, or single bytes for very low rates
+		timing.chunkSize = max(1, spec.BytesPerSecond/10)
+		timing.chunkInterval = time.Duration(timing.chunkSize) * time.Second / time.Duration(spec.BytesPerSecond)
+	}
+	if timing.chunkInterval > 0 && timing.chunkSize == 0 {
+		timing.chunkSize = 1
+	}
+	return timing, nil
+}
+
+func parseDelay(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		return 0, fieldErrorf(name, "invalid %s: '%s'", name, value)
+	}
+	return delay, nil
+}
+
+func (t *responseTiming) throttled() bool {
+	return t.chunkInterval > 0
+}
+
+// sleepContext blocks for the given duration. It returns false if ctx was
+// done before the duration elapsed.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeSegments writes the body segments, waiting for the interval between
+// segments. Segments are flushed individually if there is more than one. It
+// stops early if ctx is done.
+func writeSegments(ctx context.Context, w http.ResponseWriter, segments [][]byte, interval time.Duration) error {
+	controller := http.NewResponseController(w)
+	for i, segment := range segments {
+		if i > 0 && !sleepContext(ctx, interval) {
+			return ctx.Err()
+		}
+		if _, err := w.Write(segment); err != nil {
+			return err
+		}
+		if len(segments) == 1 {
+			break
+		}
+		if err := controller.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type timingTestSuite struct {
+	serverSuite
+}
+
+func TestTimingTestSuite(t *testing.T) {
+	suite.Run(t, new(timingTestSuite))
+}
+
+func (s *timingTestSuite) SetupTest() {
+	s.serve()
+}
+
+// reflect sends a reflection request, leaving the response body to be
+// streamed by the caller.
+func (s *timingTestSuite) reflect(ctx context.Context, spec *reflectionSpec) (*http.Response, error) {
+	body, err := json.Marshal(spec)
+	s.Require().NoError(err)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.server.URL+"/reflect", bytes.NewReader(body))
+	s.Require().NoError(err)
+	return http.DefaultClient.Do(request)
+}
+
+func (s *timingTestSuite) TestParseResponseTiming() {
+	timing, err := parseResponseTiming(&reflectionSpec{HeaderDelay: "1s", BodyDelay: "20ms", ChunkInterval: "5ms"})
+	s.Require().NoError(err)
+	s.Equal(&responseTiming{headerDelay: time.Second, bodyDelay: 20 * time.Millisecond, chunkSize: 1, chunkInterval: 5 * time.Millisecond}, timing)
+
+	timing, err = parseResponseTiming(&reflectionSpec{BytesPerSecond: 1000, ChunkInterval: "1s"})
+	s.Require().NoError(err)
+	s.Equal(100, timing.chunkSize)
+	s.Equal(100*time.Millisecond, timing.chunkInterval)
+
+	timing, err = parseResponseTiming(&reflectionSpec{BytesPerSecond: 4})
+	s.Require().NoError(err)
+	s.Equal(1, timing.chunkSize)
+	s.Equal(250*time.Millisecond, timing.chunkInterval)
+
+	timing, err = parseResponseTiming(&reflectionSpec{})
+	s.Require().NoError(err)
+	s.False(timing.throttled())
+
+	for _, spec := range []reflectionSpec{
+		{HeaderDelay: "soon"},
+		{BodyDelay: "-1s"},
+		{ChunkInterval: "5"},
+		{BytesPerSecond: -1},
+		{ChunkSize: -1},
+	} {
+		_, err := parseResponseTiming(&spec)
+		s.Error(err, spec)
+	}
+}
+
+func (s *timingTestSuite) TestHeaderDelay() {
+	start := time.Now()
+	response, err := s.reflect(context.Background(), &reflectionSpec{Status: http.StatusAccepted, HeaderDelay: "100ms"})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+
+	s.GreaterOrEqual(time.Since(start), 100*time.Millisecond)
+	s.Equal(http.StatusAccepted, response.StatusCode)
+}
+
+func (s *timingTestSuite) TestBodyDelay() {
+	start := time.Now()
+	response, err := s.reflect(context.Background(), &reflectionSpec{Body: "slow", BodyDelay: "150ms"})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+
+	// headers arrive before the body
+	s.Less(time.Since(start), 150*time.Millisecond)
+	body, err := io.ReadAll(response.Body)
+	s.Require().NoError(err)
+	s.GreaterOrEqual(time.Since(start), 150*time.Millisecond)
+	s.Equal("slow", string(body))
+}
+
+func (s *timingTestSuite) TestChunkInterval() {
+	start := time.Now()
+	response, err := s.reflect(context.Background(), &reflectionSpec{Body: "abcdef", ChunkSize: 2, ChunkInterval: "30ms"})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+
+	reader := bufio.NewReader(response.Body)
+	chunk := make([]byte, 2)
+	_, err = io.ReadFull(reader, chunk)
+	s.Require().NoError(err)
+	s.Equal("ab", string(chunk))
+	s.Less(time.Since(start), 30*time.Millisecond)
+
+	rest, err := io.ReadAll(reader)
+	s.Require().NoError(err)
+	s.Equal("cdef", string(rest))
+	// two intervals between three chunks
+	s.GreaterOrEqual(time.Since(start), 60*time.Millisecond)
+}
+
+func (s *timingTestSuite) TestBytesPerSecond() {
+	start := time.Now()
+	response, err := s.reflect(context.Background(), &reflectionSpec{Body: "abcde", BytesPerSecond: 20})
+	s.Require().NoError(err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	s.Require().NoError(err)
+	s.Equal("abcde", string(body))
+	// four intervals of 50ms between five bytes
+	s.GreaterOrEqual(time.Since(start), 200*time.Millisecond)
+}
+
+func (s *timingTestSuite) TestInvalidTiming() {
+	response, body := s.doJSON("POST", "/reflect", &reflectionSpec{Status: http.StatusOK, HeaderDelay: "forever"})
+	s.Equal(http.StatusBadRequest, response.StatusCode)
+	s.JSONEq(`{"code": "invalid_specification", "message": "invalid headerDelay: 'forever'", "field": "headerDelay"}`, body)
+}
+
+func (s *timingTestSuite) TestInvalidTiming_Configuration() {
+	for field, spec := range map[string]string{
+		"headerDelay":              `{"headerDelay": "forever"}`,
+		"bodyDelay":                `{"bodyDelay": "-1s"}`,
+		"bytesPerSecond":           `{"bytesPerSecond": -1}`,
+		"chunkSize":                `{"chunkSize": -1}`,
+		"responses[1].headerDelay": `{"responses": [{"status": 200}, {"headerDelay": "forever"}]}`,
+	} {
+		response, body := s.do("POST", "/configure_reflection", spec)
+		s.Equal(http.StatusBadRequest, response.StatusCode, spec)
+		document := &errorDocument{}
+		s.Require().NoError(json.Unmarshal([]byte(body), document))
+		s.Equal(field, document.Field, spec)
+	}
+}
+
+func (s *timingTestSuite) TestDelayHonorsCancellation() {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.reflect(ctx, &reflectionSpec{HeaderDelay: "10s"})
+	s.ErrorIs(err, context.DeadlineExceeded)
+
+	// the handler must return long before the delay elapses, otherwise
+	// closing the server blocks
+	s.server.Close()
+	s.Less(time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// DefaultSelfSignedSANs are the subject alternative names used for
+// self-signed certificates if no names are configured explicitly.
+var DefaultSelfSignedSANs = []string{"localhost", "127.0.0.1", "::1"}
+
+// TLSConfig describes the certificate used by the HTTPS listener. Either
+// CertFile and KeyFile must be set, or SelfSigned must be true.
+type TLSConfig struct {
+	// CertFile is the path to a PEM encoded certificate (chain).
+	CertFile string
+	// KeyFile is the path to the PEM encoded private key of the certificate.
+	KeyFile string
+	// SelfSigned generates an in-memory CA and a leaf certificate at startup.
+	SelfSigned bool
+	// SANs are the subject alternative names of the self-signed leaf
+	// certificate. Entries that parse as IP addresses become IP SANs, all
+	// others become DNS SANs. Defaults to DefaultSelfSignedSANs.
+	SANs []string
+}
+
+func (c *TLSConfig) validate() error {
+	if c.SelfSigned {
+		if c.CertFile != "" || c.KeyFile != "" {
+			return errors.New("a self-signed certificate can't be combined with a certificate file")
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("both a certificate file and a key file are required for TLS")
+	}
+	return nil
+}
+
+func (a *Albedo) newTLSConfig(config *TLSConfig) (*tls.Config, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	var certificate tls.Certificate
+	var err error
+	if config.SelfSigned {
+		sans := config.SANs
+		if len(sans) == 0 {
+			sans = DefaultSelfSignedSANs
+		}
+		var caPEM []byte
+		certificate, caPEM, err = generateSelfSignedCertificate(sans)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		a.log().Info("Generated self-signed certificate", "sans", sans)
+		a.log().Debug(fmt.Sprintf("Self-signed CA certificate:\n%s", caPEM))
+	} else {
+		certificate, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+	}
+	if a.adminToken != "" && a.adminClientCAs != nil {
+		// client certificates are optional and only authenticate requests to
+		// the built-in endpoints. They are verified per request, so that
+		// certificates from other CAs don't fail the handshake for ordinary
+		// traffic.
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	return tlsConfig, nil
+}
+
+// generateSelfSignedCertificate creates an in-memory CA and a leaf
+// certificate for the given subject alternative names, signed by that CA.
+// The returned certificate contains the full chain. The CA certificate is
+// also returned in PEM format, so that it can be handed to clients.
+func generateSelfSignedCertificate(sans []string) (tls.Certificate, []byte, error) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caSerial, err := randomSerialNumber()
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          caSerial,
+		Subject:               pkix.Name{CommonName: "albedo CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leafSerial, err := randomSerialNumber()
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: leafSerial,
+		Subject:      pkix.Name{CommonName: "albedo"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			leafTemplate.IPAddresses = append(leafTemplate.IPAddresses, ip)
+		} else {
+			leafTemplate.DNSNames = append(leafTemplate.DNSNames, san)
+		}
+	}
+	if len(sans) > 0 {
+		leafTemplate.Subject.CommonName = sans[0]
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leafCert, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	certificate := tls.Certificate{
+		Certificate: [][]byte{leafDER, caDER},
+		PrivateKey:  leafKey,
+		Leaf:        leafCert,
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return certificate, caPEM, nil
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type tlsTestSuite struct {
+	suite.Suite
+}
+
+func TestTlsTestSuite(t *testing.T) {
+	suite.Run(t, new(tlsTestSuite))
+}
+
+func (s *tlsTestSuite) TestGenerateSelfSignedCertificate() {
+	certificate, caPEM, err := generateSelfSignedCertificate([]string{"albedo.test", "10.0.0.1"})
+	s.Require().NoError(err)
+	s.Len(certificate.Certificate, 2)
+	s.Equal([]string{"albedo.test"}, certificate.Leaf.DNSNames)
+	s.Require().Len(certificate.Leaf.IPAddresses, 1)
+	s.Equal("10.0.0.1", certificate.Leaf.IPAddresses[0].String())
+
+	block, _ := pem.Decode(caPEM)
+	s.Require().NotNil(block)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	s.Require().NoError(err)
+	s.True(ca.IsCA)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = certificate.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "albedo.test"})
+	s.NoError(err)
+	_, err = certificate.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "other.test"})
+	s.Error(err)
+}
+
+func (s *tlsTestSuite) TestNewTLSConfig_Validation() {
+	a := New()
+	_, err := a.newTLSConfig(&TLSConfig{CertFile: "cert.pem"})
+	s.Error(err)
+	_, err = a.newTLSConfig(&TLSConfig{SelfSigned: true, CertFile: "cert.pem", KeyFile: "key.pem"})
+	s.Error(err)
+	_, err = a.newTLSConfig(&TLSConfig{CertFile: "does-not-exist.pem", KeyFile: "does-not-exist.pem"})
+	s.Error(err)
+}
+
+func (s *tlsTestSuite) TestNewTLSConfig_Files() {
+	certificate, _, err := generateSelfSignedCertificate(DefaultSelfSignedSANs)
+	s.Require().NoError(err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	s.Require().NoError(err)
+
+	dir := s.T().TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	s.Require().NoError(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0600))
+	s.Require().NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	config, err := New().newTLSConfig(&TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	s.Require().NoError(err)
+	s.Len(config.Certificates, 1)
+}
+
+func (s *tlsTestSuite) TestSelfSignedHandshake() {
+	a := New()
+	config, err := a.newTLSConfig(&TLSConfig{SelfSigned: true})
+	s.Require().NoError(err)
+
+	server := httptest.NewUnstartedServer(a.Handler())
+	server.TLS = config
+	server.StartTLS()
+	s.T().Cleanup(server.Close)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	response, err := client.Get(server.URL)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.NotNil(response.TLS)
+}
+
+func (s *tlsTestSuite) TestServe_TLSPortRequiresTLS() {
+	err := New().Serve(context.Background(), &ServeConfig{Binding: "127.0.0.1", TLSPort: 8443})
+	s.Error(err)
+}
+
+func (s *tlsTestSuite) TestServe_SideBySide() {
+	httpPort := freePort(s.T())
+	httpsPort := freePort(s.T())
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	go func() {
+		_ = New().Serve(ctx, &ServeConfig{
+			Binding: "127.0.0.1",
+			Port:    httpPort,
+			TLS:     &TLSConfig{SelfSigned: true},
+			TLSPort: httpsPort,
+		})
+	}()
+
+	client := http.Client{
+		Timeout: time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	s.Eventually(func() bool {
+		response, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/", httpPort))
+		return err == nil && response.StatusCode == http.StatusOK && response.TLS == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	s.Eventually(func() bool {
+		response, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/", httpsPort))
+		return err == nil && response.StatusCode == http.StatusOK && response.TLS != nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 type CapabilitiesSpec struct {
 	Endpoints []endpoint `json:"endpoints" yaml:"endpoints"`
 }
@@ -10,18 +12,141 @@ type reflectionSpec struct {
 	Body        string            `json:"body"`
 	EncodedBody string            `json:"encodedBody"`
 	LogMessage  string            `json:"logMessage"`
+	// timing
+	HeaderDelay    string `json:"headerDelay,omitempty"`
+	BodyDelay      string `json:"bodyDelay,omitempty"`
+	BytesPerSecond int    `json:"bytesPerSecond,omitempty"`
+	ChunkSize      int    `json:"chunkSize,omitempty"`
+	ChunkInterval  string `json:"chunkInterval,omitempty"`
+	// chunked encoding and trailers
+	Chunked         bool              `json:"chunked,omitempty"`
+	Chunks          []string          `json:"chunks,omitempty"`
+	EncodedChunks   []string          `json:"encodedChunks,omitempty"`
+	Trailers        map[string]string `json:"trailers,omitempty"`
+	EncodedTrailers map[string]string `json:"encodedTrailers,omitempty"`
+	// render body and header values as templates
+	Template bool `json:"template,omitempty"`
+	// echo the request as the body of the response
+	Echo       bool   `json:"echo,omitempty"`
+	EchoFormat string `json:"echoFormat,omitempty"`
+	// compression
+	Compress        string `json:"compress,omitempty"`
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	// raw response, written verbatim to the connection
+	RawResponse string `json:"rawResponse,omitempty"`
+	// transport level misbehavior
+	Fault *faultSpec `json:"fault,omitempty"`
+}
+
+// faultSpec describes a transport level fault to inject instead of, or while,
+// writing a response.
+type faultSpec struct {
+	Mode string `json:"mode"`
+	// ContentLength is the Content-Length to declare in mode "truncate"
+	ContentLength int `json:"contentLength,omitempty"`
 }
 
 type configureReflectionSpec struct {
 	reflectionSpec
+	Responses []reflectionSpec `json:"responses,omitempty"`
+	AfterLast string           `json:"afterLast,omitempty"`
+	// ExpiresAfter is the lifetime of the endpoints, as a Go duration
+	ExpiresAfter string `json:"expiresAfter,omitempty"`
+	// MaxHits is the number of requests the endpoints respond to
+	MaxHits   uint64                `json:"maxHits,omitempty"`
 	Endpoints []dynamicEndpointSpec `json:"endpoints"`
 }
 
+// Behaviors of a response sequence once all responses have been used.
+const (
+	// afterLastRepeat keeps responding with the last response of the sequence.
+	afterLastRepeat = "last"
+	// afterLastLoop starts over with the first response of the sequence.
+	afterLastLoop = "loop"
+	// afterLastDefault responds as if the endpoint had not been configured.
+	afterLastDefault = "default"
+)
+
+type endpointsSpec struct {
+	Endpoints []endpointStatus `json:"endpoints"`
+}
+
+// endpointStatus describes a configured dynamic endpoint and its usage.
+type endpointStatus struct {
+	ID        string    `json:"id"`
+	Method    string    `json:"method"`
+	Url       string    `json:"url"`
+	Match     string    `json:"match,omitempty"`
+	Hits      uint64    `json:"hits"`
+	Responses int       `json:"responses,omitempty"`
+	AfterLast string    `json:"afterLast,omitempty"`
+	Created   time.Time `json:"created"`
+	// Expires is unset if the endpoint doesn't expire
+	Expires       *time.Time `json:"expires,omitempty"`
+	MaxHits       uint64     `json:"maxHits,omitempty"`
+	RemainingHits *uint64    `json:"remainingHits,omitempty"`
+}
+
+// endpointDetails describes a configured dynamic endpoint, including its
+// configuration.
+type endpointDetails struct {
+	endpointStatus
+	// Configuration holds the specification of the endpoint as if it had
+	// been configured on its own.
+	Configuration configureReflectionSpec `json:"configuration"`
+}
+
 type dynamicEndpointSpec struct {
 	Method string `json:"method"`
 	Url    string `json:"url"`
+	Match  string `json:"match,omitempty"`
+	// request predicates
+	Headers     []valueMatcherSpec `json:"headers,omitempty"`
+	Query       []valueMatcherSpec `json:"query,omitempty"`
+	Body        *bodyMatcherSpec   `json:"body,omitempty"`
+	ContentType string             `json:"contentType,omitempty"`
+}
+
+// valueConditions are the conditions a value must satisfy. All configured
+// conditions must hold.
+type valueConditions struct {
+	Equals   string `json:"equals,omitempty"`
+	Contains string `json:"contains,omitempty"`
+	Regex    string `json:"regex,omitempty"`
+}
+
+// valueMatcherSpec matches a named header or query parameter.
+type valueMatcherSpec struct {
+	Name string `json:"name"`
+	valueConditions
 }
 
+// bodyMatcherSpec matches the request body, or the values selected from a
+// JSON body by JSONPath.
+type bodyMatcherSpec struct {
+	JSONPath string `json:"jsonPath,omitempty"`
+	valueConditions
+}
+
+// Match types of dynamic endpoints.
+const (
+	// matchExact matches the request URI (path and query) exactly.
+	matchExact = "exact"
+	// matchPattern matches the path with a net/http.ServeMux pattern,
+	// e.g. "/users/{id}".
+	matchPattern = "pattern"
+	// matchPrefix matches paths starting with the URL.
+	matchPrefix = "prefix"
+	// matchGlob matches the path with a path.Match pattern, e.g. "/users/*".
+	matchGlob = "glob"
+	// matchRegex matches the request URI (path and query) with a regular
+	// expression.
+	matchRegex = "regex"
+)
+
+// methodAny matches every request method.
+const methodAny = "any"
+
 type endpoint struct {
 	Path        string   `json:"path" yaml:"path"`
 	Methods     []string `json:"methods,omitempty" yaml:"methods"`
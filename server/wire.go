@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxWireBufferSize limits the number of header bytes a wireRecorder
+// retains.
+const maxWireBufferSize = 64 * 1024
+
+// maxChunkLineSize limits the length of chunk size and trailer lines in
+// chunked request bodies.
+const maxChunkLineSize = 4096
+
+// headerField is a single header line as received on the wire.
+type headerField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// wireListener wraps the connections it accepts in wireRecorders.
+type wireListener struct {
+	net.Listener
+}
+
+func (l *wireListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &wireRecorder{Conn: conn}, nil
+}
+
+// wireState is the position of a wireRecorder in the HTTP/1.x message
+// stream.
+type wireState int
+
+const (
+	wireStateHeader wireState = iota
+	wireStateBody
+	wireStateChunkSize
+	wireStateChunkData
+	wireStateChunkEnd
+	wireStateTrailer
+	// wireStateLost means the framing of the stream couldn't be followed
+	// and nothing more is recorded.
+	wireStateLost
+)
+
+// wireRecorder retains the header blocks read from a cleartext connection,
+// so that the headers of HTTP/1.x requests can be recovered as received.
+// net/http stores headers in a map, which loses their order and spelling.
+//
+// The recorder follows the message framing of the stream, skipping bodies
+// by their Content-Length or chunked encoding, so that only actual message
+// heads are recorded and a request line inside a body can't pass for the
+// header block of the next request.
+type wireRecorder struct {
+	net.Conn
+	mutex sync.Mutex
+	// blocks holds the complete header blocks not taken yet, oldest first
+	blocks [][]byte
+	// size is the total length of blocks
+	size  int
+	state wireState
+	// pending holds the partial header block or chunk line being read
+	pending []byte
+	// remaining is the number of body or chunk bytes left to skip
+	remaining int64
+}
+
+func (c *wireRecorder) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mutex.Lock()
+		c.record(p[:n])
+		c.mutex.Unlock()
+	}
+	return n, err
+}
+
+// record advances the recorder through data, collecting header blocks and
+// skipping everything else.
+func (c *wireRecorder) record(data []byte) {
+	for len(data) > 0 {
+		switch c.state {
+		case wireStateHeader:
+			data = c.recordHeader(data)
+		case wireStateBody, wireStateChunkData:
+			n := min(c.remaining, int64(len(data)))
+			data = data[n:]
+			c.remaining -= n
+			if c.remaining > 0 {
+				break
+			}
+			if c.state == wireStateBody {
+				c.state = wireStateHeader
+			} else {
+				c.state = wireStateChunkEnd
+			}
+		case wireStateChunkSize, wireStateChunkEnd, wireStateTrailer:
+			var line []byte
+			line, data = c.readLine(data)
+			if line != nil {
+				c.recordChunkLine(line)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// recordHeader appends data to the pending header block and returns the
+// data following the block, if it is complete.
+func (c *wireRecorder) recordHeader(data []byte) []byte {
+	if len(c.pending) == 0 {
+		// empty lines preceding a request line are ignored
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return nil
+		}
+	}
+	// the end of the block may start in the bytes already pending
+	offset := max(len(c.pending)-2, 0)
+	c.pending = append(c.pending, data...)
+	end := headerBlockEnd(c.pending[offset:])
+	if end < 0 {
+		if len(c.pending) > maxWireBufferSize {
+			c.lose()
+		}
+		return nil
+	}
+	end += offset
+	block, rest := slices.Clone(c.pending[:end]), c.pending[end:]
+	// rest still refers to pending, which mustn't be reused
+	c.pending = nil
+	c.startBody(block)
+	c.blocks = append(c.blocks, block)
+	c.size += len(block)
+	for c.size > maxWireBufferSize {
+		c.size -= len(c.blocks[0])
+		c.blocks = c.blocks[1:]
+	}
+	return rest
+}
+
+// startBody sets up the recorder to skip the body announced by the header
+// block, following the rules of RFC 9112, section 6.3, for requests.
+func (c *wireRecorder) startBody(block []byte) {
+	var transferEncoding, contentLength string
+	for _, field := range parseHeaderBlock(block) {
+		switch strings.ToLower(field.Name) {
+		case "transfer-encoding":
+			transferEncoding = field.Value
+		case "content-length":
+			contentLength = field.Value
+		}
+	}
+	switch {
+	case transferEncoding != "":
+		codings := strings.Split(transferEncoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			// net/http rejects the request and closes the connection
+			c.lose()
+			return
+		}
+		c.state = wireStateChunkSize
+	case contentLength != "":
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			c.lose()
+			return
+		}
+		c.remaining = length
+		if length > 0 {
+			c.state = wireStateBody
+		}
+	}
+}
+
+// readLine appends data to the pending line and returns the line once it is
+// complete, together with the data following it.
+func (c *wireRecorder) readLine(data []byte) ([]byte, []byte) {
+	index := bytes.IndexByte(data, '\n')
+	if index < 0 {
+		c.pending = append(c.pending, data...)
+		if len(c.pending) > maxChunkLineSize {
+			c.lose()
+		}
+		return nil, nil
+	}
+	line := append(c.pending, data[:index]...)
+	c.pending = c.pending[:0]
+	return bytes.TrimSuffix(line, []byte("\r")), data[index+1:]
+}
+
+// recordChunkLine handles a complete chunk size, chunk end or trailer line.
+func (c *wireRecorder) recordChunkLine(line []byte) {
+	switch c.state {
+	case wireStateChunkSize:
+		size, _, _ := bytes.Cut(line, []byte(";"))
+		length, err := strconv.ParseInt(string(bytes.TrimSpace(size)), 16, 64)
+		if err != nil || length < 0 {
+			c.lose()
+			return
+		}
+		c.remaining = length
+		if length == 0 {
+			c.state = wireStateTrailer
+		} else {
+			c.state = wireStateChunkData
+		}
+	case wireStateChunkEnd:
+		c.state = wireStateChunkSize
+	case wireStateTrailer:
+		if len(line) == 0 {
+			c.state = wireStateHeader
+		}
+	}
+}
+
+// lose stops recording, discarding any partial data.
+func (c *wireRecorder) lose() {
+	c.state = wireStateLost
+	c.pending = nil
+}
+
+// takeHeaderBlock returns the oldest recorded header block starting with
+// the given request line and discards it, along with all older blocks. It
+// returns nil if there is no such block, e.g. because the header block was
+// larger than the retained buffer.
+func (c *wireRecorder) takeHeaderBlock(requestLine string) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	line := []byte(requestLine)
+	for i, block := range c.blocks {
+		if !bytes.HasPrefix(block, line) || len(block) == len(line) {
+			continue
+		}
+		if next := block[len(line)]; next != '\r' && next != '\n' {
+			continue
+		}
+		for _, taken := range c.blocks[:i+1] {
+			c.size -= len(taken)
+		}
+		c.blocks = c.blocks[i+1:]
+		return block
+	}
+	return nil
+}
+
+// headerBlockEnd returns the offset just past the empty line terminating a
+// header block, accepting both CRLF and bare LF line endings.
+func headerBlockEnd(data []byte) int {
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\n' {
+			continue
+		}
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2
+		}
+		if i+2 < len(data) && data[i+1] == '\r' && data[i+2] == '\n' {
+			return i + 3
+		}
+	}
+	return -1
+}
+
+// parseHeaderBlock parses the header fields of a header block, preserving
+// order, spelling and duplicates. Obsolete line folding is unfolded into the
+// preceding field.
+func parseHeaderBlock(block []byte) []headerField {
+	lines := strings.Split(string(block), "\n")
+	fields := []headerField{}
+	// the first line is the request line
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			last.Value = strings.TrimSpace(last.Value + " " + strings.TrimSpace(line))
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		fields = append(fields, headerField{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return fields
+}
+
+// requestHeaders returns the headers of the request. For cleartext HTTP/1.x
+// requests received through a wireListener, the headers are returned as
+// received. Otherwise, the headers are sorted by name, preceded by the host.
+func requestHeaders(r *http.Request, wire *wireRecorder) []headerField {
+	if wire != nil && r.ProtoMajor == 1 {
+		requestLine := r.Method + " " + r.RequestURI + " " + r.Proto
+		if block := wire.takeHeaderBlock(requestLine); block != nil {
+			return parseHeaderBlock(block)
+		}
+	}
+
+	fields := []headerField{}
+	if r.Host != "" {
+		fields = append(fields, headerField{Name: "Host", Value: r.Host})
+	}
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			fields = append(fields, headerField{Name: name, Value: value})
+		}
+	}
+	return fields
+}
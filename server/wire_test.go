@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type wireTestSuite struct {
+	suite.Suite
+}
+
+func TestWireTestSuite(t *testing.T) {
+	suite.Run(t, new(wireTestSuite))
+}
+
+func (s *wireTestSuite) TestParseHeaderBlock() {
+	block := []byte("GET / HTTP/1.1\r\nHost: a\nX-Folded: first\r\n  second\r\nnot a header\r\nX-Empty:\r\n\r\n")
+	s.Equal([]headerField{
+		{Name: "Host", Value: "a"},
+		{Name: "X-Folded", Value: "first second"},
+		{Name: "X-Empty", Value: ""},
+	}, parseHeaderBlock(block))
+}
+
+func (s *wireTestSuite) TestTakeHeaderBlock() {
+	client, server := net.Pipe()
+	s.T().Cleanup(func() { client.Close() })
+	recorder := &wireRecorder{Conn: server}
+
+	data := "POST /a HTTP/1.1\r\nContent-Length: 35\r\n\r\nGET /b HTTP/1.1\r\nX-Smuggled: yes\r\n\r\n" +
+		"\r\nGET /b HTTP/1.1\nX: y\n\n"
+	go func() {
+		_, _ = client.Write([]byte(data))
+	}()
+	buffer := make([]byte, len(data))
+	read := 0
+	for read < len(data) {
+		n, err := recorder.Read(buffer[read:])
+		s.Require().NoError(err)
+		read += n
+	}
+
+	s.Equal("POST /a HTTP/1.1\r\nContent-Length: 35\r\n\r\n", string(recorder.takeHeaderBlock("POST /a HTTP/1.1")))
+	// the request line in the body is not a header block
+	s.Equal("GET /b HTTP/1.1\nX: y\n\n", string(recorder.takeHeaderBlock("GET /b HTTP/1.1")))
+	s.Nil(recorder.takeHeaderBlock("GET /b HTTP/1.1"))
+	s.Empty(recorder.blocks)
+	s.Zero(recorder.size)
+}
+
+func (s *wireTestSuite) TestRecord_Chunked() {
+	recorder := &wireRecorder{}
+	data := "POST /a HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n" +
+		"13;ext=1\r\nGET /b HTTP/1.1\r\n\r\n\r\n" +
+		"a\r\n\r\nX: y\r\n\r\n\r\n" +
+		"0\r\nGET /b HTTP/1.1\r\n\r\n" +
+		"GET /c HTTP/1.1\r\n\r\n"
+	// byte by byte, to cover blocks and lines spanning reads
+	for i := range len(data) {
+		recorder.record([]byte{data[i]})
+	}
+
+	s.Nil(recorder.takeHeaderBlock("GET /b HTTP/1.1"))
+	s.Equal("POST /a HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", string(recorder.takeHeaderBlock("POST /a HTTP/1.1")))
+	s.Equal("GET /c HTTP/1.1\r\n\r\n", string(recorder.takeHeaderBlock("GET /c HTTP/1.1")))
+	s.Equal(wireStateHeader, recorder.state)
+}
+
+func (s *wireTestSuite) TestRecord_Limits() {
+	recorder := &wireRecorder{}
+	body := bytes.Repeat([]byte("GET /b HTTP/1.1\r\n\r\n"), maxWireBufferSize)
+	recorder.record([]byte(fmt.Sprintf("POST /a HTTP/1.1\r\nContent-Length: %d\r\n\r\n", len(body))))
+	for i := 0; i < len(body); i += 1000 {
+		recorder.record(body[i:min(i+1000, len(body))])
+	}
+	recorder.record([]byte("GET /c HTTP/1.1\r\n\r\n"))
+	// bodies are skipped, not retained
+	s.Len(recorder.blocks, 2)
+	s.Empty(recorder.pending)
+	s.NotNil(recorder.takeHeaderBlock("POST /a HTTP/1.1"))
+	s.NotNil(recorder.takeHeaderBlock("GET /c HTTP/1.1"))
+
+	// old blocks are dropped once the retained blocks exceed the limit
+	block := fmt.Sprintf("GET /d HTTP/1.1\r\nX: %s\r\n\r\n", strings.Repeat("x", maxWireBufferSize/2))
+	recorder.record([]byte(block + block + "GET /e HTTP/1.1\r\n\r\n"))
+	s.Len(recorder.blocks, 2)
+	s.LessOrEqual(recorder.size, maxWireBufferSize)
+
+	// header blocks larger than the limit stop the recording
+	recorder.record([]byte("GET /f HTTP/1.1\r\nX: " + strings.Repeat("x", maxWireBufferSize) + "\r\n"))
+	s.Equal(wireStateLost, recorder.state)
+	recorder.record([]byte("\r\nGET /g HTTP/1.1\r\n\r\n"))
+	s.Nil(recorder.takeHeaderBlock("GET /g HTTP/1.1"))
+
+	// unsupported framing stops the recording as well
+	recorder = &wireRecorder{}
+	recorder.record([]byte("POST /a HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n"))
+	s.Equal(wireStateLost, recorder.state)
+}